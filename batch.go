@@ -0,0 +1,157 @@
+package freeswitch
+
+import "context"
+
+// BatchResult is the outcome of a single command run by ExecuteBatch().
+type BatchResult struct {
+	// The command's result, as would be returned by Execute().
+	Result string
+
+	// The error that would be returned by Execute(), if any.
+	Err error
+}
+
+// BatchJob is the outcome of a single command queued by QueryBatch().
+type BatchJob struct {
+	// The channel through which the command's result will be received, as would be returned by Query(). Nil if Err
+	// is set.
+	Result chan string
+
+	// The error that would be returned by Query(), if any.
+	Err error
+}
+
+// ExecuteBatch runs several API commands, and returns their results in the same order as the commands. The commands
+// are written to the connection together, so that none waits for a response to another before being sent, and
+// commands run by other goroutines are not interleaved with them.
+//
+// Each result is the same as would be returned by Execute(). For example:
+//
+//	results := c.ExecuteBatch(
+//		freeswitch.UUIDSetVar{UUID: uuid, Name: "hold_music", Value: "silence"},
+//		freeswitch.UUIDKill{UUID: otherUUID},
+//		freeswitch.RawCommand{App: "uuid_record", Args: []string{uuid, "start", path}},
+//	)
+//	for _, r := range results {
+//		if r.Err != nil {
+//			log.Println(r.Err)
+//		}
+//	}
+//
+// Results are not parsed, unless CheckResults is true; see ParseResult(). If PreventSocketBlocking is true, the
+// commands are run with QueryBatch(), and their jobs awaited. Commands that can't be encoded, such as an Originate
+// whose variables contain unbalanced delimiters, fail without being sent, here and in QueryBatch().
+func (c *Client) ExecuteBatch(commands ...APICommand) []BatchResult {
+	return c.ExecuteBatchContext(context.Background(), commands...)
+}
+
+// ExecuteBatchContext is the same as ExecuteBatch(), but gives up waiting for results when the given context is
+// done. See ExecuteContext().
+func (c *Client) ExecuteBatchContext(ctx context.Context, commands ...APICommand) []BatchResult {
+	results := make([]BatchResult, len(commands))
+	if c.PreventSocketBlocking {
+		for i, job := range c.QueryBatchContext(ctx, commands...) {
+			if results[i].Err = job.Err; job.Err == nil {
+				select {
+				case results[i].Result = <-job.Result:
+					// An abandoned job's empty result may be received before the context is seen to be done
+					if results[i].Result == "" && ctx.Err() != nil {
+						results[i].Err = ctx.Err()
+					}
+				case <-ctx.Done():
+					results[i].Err = ctx.Err()
+				}
+			}
+		}
+	} else {
+		var (
+			cmds  []*command
+			index []int // the position in commands of each of cmds
+		)
+		for i, cmd := range commands {
+			if results[i].Err = validate(cmd); results[i].Err == nil {
+				app, args := cmd.Command()
+				cmds = append(cmds, newCommand(append([]string{"api", app}, args...)))
+				index = append(index, i)
+			}
+		}
+		errs := c.enqueueLimited(ctx, c.apiLimiter, c.APILimits, cmds, func(j int, release func()) {
+			cmds[j].release = release
+		})
+		for j, cmd := range cmds {
+			i := index[j]
+			if results[i].Err = errs[j]; errs[j] == nil {
+				var p packet
+				if p, results[i].Err = cmd.wait(ctx); p != nil {
+					results[i].Result = p.String()
+				}
+			}
+		}
+	}
+	if c.CheckResults {
+		for i, result := range results {
+			if result.Err == nil {
+				app, _ := commands[i].Command()
+				_, results[i].Err = parseResult(app, result.Result)
+			}
+		}
+	}
+	return results
+}
+
+// QueryBatch runs several API commands as background jobs, and returns their jobs in the same order as the commands.
+// The commands are written to the connection together, as with ExecuteBatch(). It returns once FreeSWITCH has
+// accepted or refused every command, and each job is the same as the result and error that would be returned by
+// Query(). Refused commands have an *APIError, or ECommandFailed if FreeSWITCH gave no reason.
+func (c *Client) QueryBatch(commands ...APICommand) []BatchJob {
+	return c.QueryBatchContext(context.Background(), commands...)
+}
+
+// QueryBatchContext is the same as QueryBatch(), but with a context that applies to every job. See QueryContext().
+func (c *Client) QueryBatchContext(ctx context.Context, commands ...APICommand) []BatchJob {
+	var (
+		jobs  = make([]BatchJob, len(commands))
+		cmds  []*command
+		index []int // the position in commands of each of cmds
+	)
+	for i, cmd := range commands {
+		if jobs[i].Err = validate(cmd); jobs[i].Err == nil {
+			cmds = append(cmds, newJobCommand(cmd.Command()))
+			index = append(index, i)
+			jobs[i].Result = make(chan string, 1)
+		}
+	}
+	errs := c.enqueueLimited(ctx, c.bgAPILimiter, c.BgAPILimits, cmds, func(j int, release func()) {
+		// Registered before the command is sent, so that its result can't arrive first
+		c.addJob(cmds[j].jobID, jobs[index[j]].Result, release)
+	})
+	for j, cmd := range cmds {
+		i := index[j]
+		if jobs[i].Err = errs[j]; errs[j] == nil {
+			var p packet
+			if p, jobs[i].Err = cmd.wait(ctx); p != nil {
+				jobs[i].Err = refusal(commands[i], p)
+			}
+		}
+		if jobs[i].Err != nil {
+			jobs[i].Result = nil
+			c.removeJob(cmd.jobID)
+		} else {
+			c.abandonJobWhenDone(ctx, cmd.jobID)
+		}
+	}
+	return jobs
+}
+
+// Returns an error if FreeSWITCH refused to run the given command as a background job, in which case no
+// BACKGROUND_JOB event will follow.
+func refusal(command APICommand, p packet) error {
+	if r, ok := p.(*reply); ok && !r.ok() {
+		app, _ := command.Command()
+		if _, err := parseResult(app, r.String()); err != nil {
+			return err
+		}
+		return ECommandFailed
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package freeswitch
+
+import (
+	"context"
+	"sync"
+)
+
+// Call is a handle to a call made with Client.Originate(). It tracks the call's A-leg through its CHANNEL_ANSWER and
+// CHANNEL_HANGUP_COMPLETE events.
+type Call struct {
+	// The unique ID of the call's A-leg channel.
+	UUID string
+
+	client      *Client
+	answered    chan struct{}
+	done        chan struct{}
+	answerOnce  sync.Once
+	doneOnce    sync.Once
+	answerEvent *Event // set before answered is closed
+	hangupEvent *Event // set before done is closed
+	err         error  // set before done is closed
+	accepted    bool   // true once FreeSWITCH has accepted the originate command; use the client's callsLock
+}
+
+// Originate starts a call in the background, and returns a handle to it once FreeSWITCH has accepted the command.
+// The call's UUID is set in advance with the origination_uuid variable, unless that variable is already given.
+//
+// Events for the call are received through the client's handlers, so the client must be connected (or connecting)
+// for the handle to be notified of the call's progress. If the client disconnects before the call hangs up, its
+// hangup can't be seen, so the handle ends with ENotConnected.
+func (c *Client) Originate(o Originate) (*Call, error) {
+	return c.OriginateContext(context.Background(), o)
+}
+
+// OriginateContext is the same as Originate(), but gives up if the given context is done before FreeSWITCH accepts
+// the command. Once accepted, the call itself is unaffected by the context, but if the context is done before the
+// originate job completes, the handle stops following the call, and ends with the context's error.
+func (c *Client) OriginateContext(ctx context.Context, o Originate) (*Call, error) {
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+	c.callsOnce.Do(func() {
+		// A call's events must be handled in order, or its hangup could be seen before its answer.
+		group := &handlerGroup{ordered: true}
+		c.onGroup(EventName{"CHANNEL_ANSWER", ""}, group, c.callEvent)
+		c.onGroup(EventName{"CHANNEL_HANGUP_COMPLETE", ""}, group, c.callEvent)
+	})
+
+	variables := Variables{}
+	for name, value := range o.Variables {
+		variables[name] = value
+	}
+	if variables["origination_uuid"] == "" {
+		variables["origination_uuid"] = newUUID()
+	}
+	o.Variables = variables
+
+	call := &Call{
+		UUID:     variables["origination_uuid"],
+		client:   c,
+		answered: make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	exclusive(&c.callsLock, func() { c.calls[call.UUID] = call })
+
+	app, args := o.Command()
+	result, err := c.QueryContext(ctx, app, args...)
+	if err != nil {
+		c.removeCall(call.UUID)
+		return nil, err
+	}
+	exclusive(&c.callsLock, func() { call.accepted = true })
+	go func() {
+		r := <-result
+		_, err := parseResult(app, r)
+		if r == "" {
+			// The job was abandoned, or the connection was lost, so the call's progress can't be followed.
+			if err = ctx.Err(); err == nil {
+				err = ENotConnected
+			}
+		}
+		if err != nil {
+			call.end(nil, err)
+		}
+	}()
+	return call, nil
+}
+
+// Answered returns a channel that is closed when the call is answered.
+func (call *Call) Answered() <-chan struct{} {
+	return call.answered
+}
+
+// Done returns a channel that is closed when the call has hung up, or failed to start.
+func (call *Call) Done() <-chan struct{} {
+	return call.done
+}
+
+// Err returns the reason the call failed to start, or stopped being followed, if it did, once Done() is closed.
+func (call *Call) Err() error {
+	select {
+	case <-call.done:
+		return call.err
+	default:
+		return nil
+	}
+}
+
+// WaitAnswer blocks until the call is answered, and returns its CHANNEL_ANSWER event. If the call ends first, the
+// reason it failed to start is returned, or ENotAnswered. If the given context is done first, its error is returned.
+func (call *Call) WaitAnswer(ctx context.Context) (*Event, error) {
+	select {
+	case <-call.answered:
+		return call.answerEvent, nil
+	case <-call.done:
+		select {
+		case <-call.answered:
+			return call.answerEvent, nil
+		default:
+		}
+		if call.err != nil {
+			return nil, call.err
+		}
+		return nil, ENotAnswered
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// WaitHangup blocks until the call has hung up, and returns its CHANNEL_HANGUP_COMPLETE event. If the call failed to
+// start, the reason is returned, or ENotConnected if the client disconnected first. If the given context is done
+// first, its error is returned.
+func (call *Call) WaitHangup(ctx context.Context) (*Event, error) {
+	select {
+	case <-call.done:
+		return call.hangupEvent, call.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Hangup hangs up the call with the given cause, e.g. "NORMAL_CLEARING". Use an empty cause for FreeSWITCH's default.
+func (call *Call) Hangup(cause string) error {
+	_, err := call.client.Run(UUIDKill{UUID: call.UUID, Cause: cause})
+	return err
+}
+
+func (call *Call) end(hangupEvent *Event, err error) {
+	call.doneOnce.Do(func() {
+		call.client.removeCall(call.UUID)
+		call.hangupEvent = hangupEvent
+		call.err = err
+		close(call.done)
+	})
+}
+
+func (c *Client) callEvent(e *Event) {
+	var call *Call
+	exclusive(&c.callsLock, func() { call = c.calls[e.Get("Unique-ID")] })
+	if call == nil {
+		return
+	}
+	switch e.Name().Name {
+	case "CHANNEL_ANSWER":
+		call.answerOnce.Do(func() {
+			call.answerEvent = e
+			close(call.answered)
+		})
+	case "CHANNEL_HANGUP_COMPLETE":
+		call.end(e, nil)
+	}
+}
+
+// End every call that FreeSWITCH has accepted with the given error. Called on disconnection, after which their hangups
+// can't be seen. Calls not yet accepted are left to their originate commands, which may yet be sent on reconnection.
+func (c *Client) endCalls(err error) {
+	var ended []*Call
+	exclusive(&c.callsLock, func() {
+		for uuid, call := range c.calls {
+			if call.accepted {
+				ended = append(ended, call)
+				delete(c.calls, uuid)
+			}
+		}
+	})
+	for _, call := range ended {
+		call.end(nil, err)
+	}
+}
+
+func (c *Client) removeCall(uuid string) {
+	exclusive(&c.callsLock, func() { delete(c.calls, uuid) })
+}
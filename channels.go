@@ -0,0 +1,249 @@
+package freeswitch
+
+import (
+	"sync"
+	"time"
+)
+
+// How long a hung-up channel's UUID is remembered, so that late events don't bring it back to life.
+const channelTombstoneDuration = time.Minute
+
+// ChannelState is the state of a channel tracked by a ChannelRegistry.
+type ChannelState int
+
+const (
+	ChannelCreated ChannelState = iota
+	ChannelRinging
+	ChannelAnswered
+	ChannelBridged
+	ChannelHeld
+	ChannelHungUp
+)
+
+var channelStateNames = []string{"created", "ringing", "answered", "bridged", "held", "hung up"}
+
+// The name of the state, e.g. "answered".
+func (s ChannelState) String() string {
+	if s >= 0 && int(s) < len(channelStateNames) {
+		return channelStateNames[s]
+	}
+	return "unknown"
+}
+
+// Channel is a snapshot of a channel tracked by a ChannelRegistry.
+type Channel struct {
+	// The channel's Unique-ID.
+	UUID string
+
+	// The channel's state at the time of the snapshot.
+	State ChannelState
+
+	// The UUID of the channel to which this one is bridged, if any.
+	BridgedTo string
+
+	// The most recent event received for the channel.
+	Event *Event
+}
+
+// ChannelStateHandler is a function that can be registered to be notified of channel state changes. It receives a
+// snapshot of the channel in its new state, and the state it was in before. Handlers are also notified when a channel
+// is first seen, with a previous state of ChannelCreated.
+type ChannelStateHandler func(channel Channel, previous ChannelState)
+
+// ChannelRegistry maintains the state of live channels from the client's CHANNEL_* events. Use
+// Client.TrackChannels() to make one.
+//
+// Events may be handled out of order (see Client.On()), so the registry ignores events with a lower Event-Sequence
+// than the last one it applied to each channel.
+//
+// Channels may end without the client seeing their events while it's disconnected, and a restarted FreeSWITCH begins
+// its sequence again, so the registry is emptied whenever the client disconnects, without notifying handlers. Live
+// channels are added again as their events arrive after reconnection.
+type ChannelRegistry struct {
+	lock     sync.Mutex
+	channels map[string]*trackedChannel
+	ended    map[string]time.Time
+	handlers []ChannelStateHandler
+}
+
+type trackedChannel struct {
+	Channel
+	sequence int
+}
+
+// Events that affect channel state.
+var channelEventNames = []string{
+	"CHANNEL_CREATE",
+	"CHANNEL_PROGRESS",
+	"CHANNEL_PROGRESS_MEDIA",
+	"CHANNEL_ANSWER",
+	"CHANNEL_BRIDGE",
+	"CHANNEL_UNBRIDGE",
+	"CHANNEL_HOLD",
+	"CHANNEL_UNHOLD",
+	"CHANNEL_HANGUP",
+	"CHANNEL_HANGUP_COMPLETE",
+	"CHANNEL_DESTROY",
+}
+
+// TrackChannels starts tracking the state of live channels, and returns the client's channel registry. It can be
+// called any number of times, and always returns the same registry. Channels that already exist when tracking
+// starts are added as their events arrive.
+func (c *Client) TrackChannels() *ChannelRegistry {
+	c.channelsOnce.Do(func() {
+		r := &ChannelRegistry{
+			channels: map[string]*trackedChannel{},
+			ended:    map[string]time.Time{},
+		}
+		for _, name := range channelEventNames {
+			c.on(EventName{name, ""}, r.handle)
+		}
+		c.channels.Store(r)
+	})
+	return c.channels.Load()
+}
+
+// Get returns a snapshot of the channel with the given UUID, and true if it was found.
+func (r *ChannelRegistry) Get(uuid string) (channel Channel, ok bool) {
+	exclusive(&r.lock, func() {
+		var tracked *trackedChannel
+		if tracked, ok = r.channels[uuid]; ok {
+			channel = tracked.Channel
+		}
+	})
+	return
+}
+
+// All returns snapshots of every live channel, in no particular order.
+func (r *ChannelRegistry) All() (channels []Channel) {
+	exclusive(&r.lock, func() {
+		channels = make([]Channel, 0, len(r.channels))
+		for _, tracked := range r.channels {
+			channels = append(channels, tracked.Channel)
+		}
+	})
+	return
+}
+
+// Len returns the number of live channels.
+func (r *ChannelRegistry) Len() (count int) {
+	exclusive(&r.lock, func() { count = len(r.channels) })
+	return
+}
+
+// OnStateChange registers a handler to be called whenever a channel changes state. Handlers are called from the
+// goroutine handling the event that caused the change.
+func (r *ChannelRegistry) OnStateChange(handler ChannelStateHandler) {
+	exclusive(&r.lock, func() { r.handlers = append(r.handlers, handler) })
+}
+
+func (r *ChannelRegistry) handle(e *Event) {
+	var (
+		name    = e.Name().Name
+		changes []func()
+	)
+	exclusive(&r.lock, func() {
+		switch name {
+		case "CHANNEL_BRIDGE", "CHANNEL_UNBRIDGE":
+			// Both legs are affected, but the event is only raised on one of them.
+			a, b := e.Get("Bridge-A-Unique-ID"), e.Get("Bridge-B-Unique-ID")
+			if a == "" {
+				a, b = e.Get("Unique-ID"), e.Get("Other-Leg-Unique-ID")
+			}
+			changes = append(changes, r.apply(a, b, e, name), r.apply(b, a, e, name))
+		default:
+			changes = append(changes, r.apply(e.Get("Unique-ID"), "", e, name))
+		}
+	})
+	for _, change := range changes {
+		if change != nil {
+			change()
+		}
+	}
+}
+
+// Apply an event to a channel. Must be called with the lock held. Returns a function that calls state change
+// handlers, which should be called after the lock is released, or nil if the state didn't change.
+func (r *ChannelRegistry) apply(uuid, other string, e *Event, name string) func() {
+	if uuid == "" {
+		return nil
+	}
+	if _, ended := r.ended[uuid]; ended {
+		return nil
+	}
+	tracked, exists := r.channels[uuid]
+	if !exists {
+		tracked = &trackedChannel{Channel: Channel{UUID: uuid}}
+		r.channels[uuid] = tracked
+	}
+	sequence := e.Sequence()
+	if sequence != 0 && sequence < tracked.sequence {
+		return nil
+	}
+	tracked.sequence = sequence
+	tracked.Event = e
+
+	previous := tracked.State
+	switch name {
+	case "CHANNEL_PROGRESS", "CHANNEL_PROGRESS_MEDIA":
+		if tracked.State < ChannelRinging {
+			tracked.State = ChannelRinging
+		}
+	case "CHANNEL_ANSWER":
+		if tracked.State < ChannelAnswered {
+			tracked.State = ChannelAnswered
+		}
+	case "CHANNEL_BRIDGE":
+		tracked.State = ChannelBridged
+		tracked.BridgedTo = other
+	case "CHANNEL_UNBRIDGE":
+		tracked.State = ChannelAnswered
+		tracked.BridgedTo = ""
+	case "CHANNEL_HOLD":
+		tracked.State = ChannelHeld
+	case "CHANNEL_UNHOLD":
+		if tracked.BridgedTo != "" {
+			tracked.State = ChannelBridged
+		} else {
+			tracked.State = ChannelAnswered
+		}
+	case "CHANNEL_HANGUP", "CHANNEL_HANGUP_COMPLETE", "CHANNEL_DESTROY":
+		tracked.State = ChannelHungUp
+		if name != "CHANNEL_HANGUP" {
+			r.end(uuid)
+		}
+	}
+
+	if exists && tracked.State == previous {
+		return nil
+	}
+	var (
+		channel  = tracked.Channel
+		handlers = r.handlers
+	)
+	return func() {
+		for _, handler := range handlers {
+			handler(channel, previous)
+		}
+	}
+}
+
+// Forget every channel, and every hung-up channel's UUID. See ChannelRegistry.
+func (r *ChannelRegistry) reset() {
+	exclusive(&r.lock, func() {
+		r.channels = map[string]*trackedChannel{}
+		r.ended = map[string]time.Time{}
+	})
+}
+
+// Remove a channel, and remember its UUID for a while so that late events are ignored.
+func (r *ChannelRegistry) end(uuid string) {
+	now := time.Now()
+	delete(r.channels, uuid)
+	for ended, at := range r.ended {
+		if now.Sub(at) > channelTombstoneDuration {
+			delete(r.ended, ended)
+		}
+	}
+	r.ended[uuid] = now
+}
@@ -7,11 +7,10 @@
 package freeswitch
 
 import (
-	"bufio"
+	"context"
 	"errors"
-	"io"
 	"net"
-	"net/textproto"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -44,42 +43,180 @@ type Client struct {
 	// Optional. Called when sending and receiving data to/from FreeSWITCH.
 	Logger func(packet string, isOutbound bool)
 
+	// The format in which FreeSWITCH should send events (default EventFormatPlain). JSON is cheaper to parse for
+	// high-volume event streams. To avoid races, don't change its value while connected.
+	EventFormat EventFormat
+
+	// If true, Execute() and its siblings return an *APIError when a command's result begins with "-ERR" or
+	// "-USAGE", along with the result itself. Query() results are unaffected; use ParseResult() on them.
+	CheckResults bool
+
 	// Advanced. If true, only "bgapi" commands will be used. This will not affect the client's behaviour, but
 	// may affect performance of FreeSWITCH (for better or worse). If in doubt, leave it false. To avoid races,
 	// don't change its value while connected.
 	PreventSocketBlocking bool
 
+	// Limits on the rate and concurrency of api commands, i.e. those run by Execute() and its siblings, and of bgapi
+	// commands, i.e. those run by Query() and its siblings. With PreventSocketBlocking, Execute() uses BgAPILimits.
+	// Other commands, such as those that subscribe to events, are never limited. See Limits. To avoid races, don't
+	// change their values while connected.
+	APILimits   Limits
+	BgAPILimits Limits
+
 	// When false, commands that are interrupted by a disconnection will continue waiting until Timeout is reached,
 	// or until the client re-connects and the command is accepted. When true, disconnection will cause immediate
 	// command failure. Only commands interrupted by disconnection are affected; commands attempted while disconnected
-	// will wait up to Timeout for (re-)connection. If you are running Connect() in a retry loop, leave this false.
-	// See Execute().
+	// will wait up to Timeout for (re-)connection. If you are running Connect() in a retry loop, or using Supervise(),
+	// leave this false. See Execute().
 	FailOnDisconnect bool
 
+	// Optional. Called when the state of the client's connection changes. When the state changes to
+	// StateDisconnected, err is the reason for disconnection, which is nil after Shutdown(). The function is called
+	// synchronously by the connecting goroutine, so it must not block, and must not call Execute(), On() etc.
+	OnStateChange func(state ConnectionState, err error)
+
+	// Delays between reconnection attempts made by Supervise(). See Backoff.
+	Backoff Backoff
+
+	// How events are passed to handlers. The default is a goroutine per handler per event. See DispatchOptions. To
+	// avoid races, don't change its value while connected.
+	Dispatch DispatchOptions
+
+	// Optional. Called when an event handler panics, with the event, the value passed to panic(), and the stack trace.
+	// Panics are always recovered, so a bad handler can't crash the process, and it continues to receive later events.
+	OnHandlerPanic func(e *Event, recovered interface{}, stack []byte)
+
+	// Optional. If set, panics recovered from event handlers are sent to this channel as *HandlerPanic errors. Sends
+	// don't block, so errors are discarded if the channel isn't ready to receive them.
+	Errors chan<- error
+
 	conn     net.Conn
 	inbox    chan *rawPacket
-	outbox   chan *command
-	errors   chan error
+	outbox   chan []*command
+	errors   chan error      // buffered, so that close() doesn't wait for the connection to take the error
+	ended    <-chan struct{} // if set, closed once the client can't connect again, so that commands fail straight away
 	reading  chan struct{}
 	running  int32
 	handlers handlerMap
 	control  sync.Mutex
-	jobs     map[string]chan string // use jobsJock when reading/writing
+	jobs     map[string]*backgroundJob // use jobsJock when reading/writing
 	jobsLock sync.Mutex
+
+	apiLimiter   *limiter
+	bgAPILimiter *limiter
+
+	state     int32
+	connected uint32        // number of times the client has reached StateSubscribed
+	ready     chan struct{} // use readyLock when reading/writing
+	readyLock sync.Mutex
+	halt      context.CancelFunc // use haltLock when reading/writing
+	shutdown  bool               // true once Shutdown() has been called; use haltLock when reading/writing
+	haltLock  sync.Mutex
+	calls     map[string]*Call // use callsLock when reading/writing
+	callsLock sync.Mutex
+	callsOnce sync.Once
+
+	channels     atomic.Pointer[ChannelRegistry]
+	channelsOnce sync.Once
+	dropped      atomic.Uint64 // events dropped by dispatch queues and subscriptions
+
+	// The following are guarded by control, and restored by restoreConfiguration() on each connection.
+	filters      []*filterUse
+	myEvents     string
+	divertEvents bool
 }
 
 // EventHandler is a function that can be registered to handle events.
 type EventHandler func(*Event)
-type handlerMap map[EventName][]EventHandler
+type handlerMap map[EventName][]*registeredHandler
+
+// Returns the handlers that should receive events with the given name, including wildcard handlers. Handlers
+// registered together by OnMany() are included only once.
+func (m handlerMap) match(name *EventName) (handlers []*registeredHandler) {
+	handlers = m[*name][:]
+	for n, registered := range m {
+		if n.isWildcard() && n.matches(name) {
+			for _, h := range registered {
+				if !containsGroup(handlers, h.group) {
+					handlers = append(handlers[:len(handlers):len(handlers)], h)
+				}
+			}
+		}
+	}
+	return
+}
 
-type command struct {
-	command  []string
-	response chan packet
+func containsGroup(handlers []*registeredHandler, group *handlerGroup) bool {
+	for _, h := range handlers {
+		if h.group == group {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the names of events that have handlers, in a predictable order.
+func (m handlerMap) names() []EventName {
+	names := make([]EventName, 0, len(m))
+	for n := range m {
+		names = append(names, n)
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i].String() < names[j].String() })
+	return names
+}
+
+// Returns true if any handlers need a subscription to all events.
+func (m handlerMap) needsAll() bool {
+	for n := range m {
+		if n.isWildcard() {
+			return true
+		}
+	}
+	return false
+}
+
+// Wraps a handler, so that it can be found again for removal.
+type registeredHandler struct {
+	handler EventHandler
+	group   *handlerGroup
+	removed int32 // set atomically when the handler is removed, so that queued events are not passed to it
+}
+
+// Registrations of one handler under several names, made together by OnMany(). DispatchSerial gives each group a
+// single queue, so that its handler sees all of its events in order.
+type handlerGroup struct {
+	active  int32 // number of registrations not yet removed; use atomically
+	ordered bool  // if true, the group has a queue in every Dispatch mode, not just DispatchSerial
+}
+
+// A background job awaiting its BACKGROUND_JOB event.
+type backgroundJob struct {
+	result  chan string
+	release func()      // frees the job's place under BgAPILimits
+	stop    func() bool // if set, stops the job from being abandoned when its context is done
+}
+
+// Free the job's resources, once it has completed or been abandoned.
+func (job *backgroundJob) finish() {
+	job.release()
+	if job.stop != nil {
+		job.stop()
+	}
+}
+
+func (h *registeredHandler) isRemoved() bool {
+	return atomic.LoadInt32(&h.removed) == 1
 }
 
 // NewClient makes a new client with the default hostname, password, port, and timeout. It will attempt to read
 // FreeSWITCH's event socket configuration file to obtain connection details.
 func NewClient() *Client {
+	c := newClient()
+	c.guessConfiguration()
+	return c
+}
+
+func newClient() *Client {
 	c := &Client{
 		Hostname: defaultHostname,
 		Password: defaultPassword,
@@ -87,20 +224,30 @@ func NewClient() *Client {
 		Timeout:  defaultTimeout,
 
 		inbox:   make(chan *rawPacket),
-		outbox:  make(chan *command),
-		jobs:    map[string]chan string{},
-		errors:  make(chan error),
+		outbox:  make(chan []*command),
+		jobs:    map[string]*backgroundJob{},
+		calls:   map[string]*Call{},
+		errors:  make(chan error, 1),
 		reading: make(chan struct{}),
+		ready:   make(chan struct{}),
+
+		apiLimiter:   newLimiter(),
+		bgAPILimiter: newLimiter(),
 	}
 	// TODO: exclude background job listener when not needed
-	c.handlers = handlerMap{{"BACKGROUND_JOB", ""}: {c.bgJobDone}}
-	c.guessConfiguration()
+	c.handlers = handlerMap{{"BACKGROUND_JOB", ""}: {{handler: c.bgJobDone, group: &handlerGroup{active: 1}}}}
 	return c
 }
 
 // Connect to FreeSWITCH and block until disconnection. Call this method in its own goroutine, and call Shutdown()
 // to make it return with no error.
-func (c *Client) Connect() (err error) {
+func (c *Client) Connect() error {
+	return c.ConnectContext(context.Background())
+}
+
+// ConnectContext is the same as Connect(), but gives up connecting or handshaking, or closes an established
+// connection, when the given context is done. In that case, it returns the context's error.
+func (c *Client) ConnectContext(ctx context.Context) (err error) {
 	c.control.Lock()
 	defer c.control.Unlock()
 
@@ -114,151 +261,164 @@ func (c *Client) Connect() (err error) {
 		return EBlankHostname
 	}
 
-	// Flag set by loop when receiving an error through the errors channel, to avoid an extra read
-	var receivedError bool
-
 	// Attempt TCP connection to FreeSWITCH
-	if c.conn, err = net.DialTimeout("tcp", c.Hostname+":"+strconv.Itoa(int(c.Port)), c.Timeout); err == nil {
-
-		// Start reading packets from FS and pumping them into the inbox channel. This process can be interrupted
-		// by closing the connection, then waiting on the `reading` channel for it to exit.
-		go c.read()
-
-		var (
-			// This timeout will cover authentication and event subscription.
-			handshakeTimeout = time.After(c.Timeout)
-
-			// Call this function to wait for a packet within the handshake timeout.
-			handshake = func(handler func(*rawPacket)) {
-				if err == nil {
-					select {
-					case packet := <-c.inbox:
-						handler(packet)
-					case <-handshakeTimeout:
-						err = ETimeout
-					}
-				}
-			}
+	c.setState(StateConnecting, nil)
+	dialer := &net.Dialer{Timeout: c.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", c.Hostname+":"+strconv.Itoa(int(c.Port)))
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return c.stop(err, false)
+	}
+	return c.serve(ctx, conn, c.authenticate, nil)
+}
 
-			// Expect an OK response, setting the given error if one is not received.
-			expectOK = func(onFail error) {
-				handshake(func(response *rawPacket) {
-					if result, ok := response.cast().(*reply); !ok || !result.ok() {
-						err = onFail
-					}
-				})
-			}
-		)
-
-		// Wait the given timeout for FreeSWITCH to request authentication and, when requested, send it a password.
-		handshake(func(authPacket *rawPacket) {
-			if authPacket.packetType() == ptAuthRequest {
-				err = c.write("auth", c.Password)
-			} else {
-				err = EUnexpectedResponse
-			}
-		})
+// Run the event socket protocol over the given connection, and block until disconnection. The caller must hold the
+// control lock, and must have flagged the client as running. The greet function performs the opening handshake
+// (inbound authentication, or an outbound "connect"), after which events for already-defined handlers are subscribed
+// to. If the handshake succeeds, begin (if not nil) is called just before normal operation starts. When the given
+// context is done, the connection is closed with the context's error.
+func (c *Client) serve(ctx context.Context, conn net.Conn, greet func(*handshake), begin func()) (err error) {
+	c.conn = conn
 
-		// Still within the auth timeout, wait for an authentication response, and set an error if it fails.
-		expectOK(EAuthenticationFailed)
+	stopWatching := context.AfterFunc(ctx, func() { c.close(ctx.Err()) })
+	defer stopWatching()
 
-		// Listen to events for already-defined event handlers.
-		if err == nil && len(c.handlers) > 0 {
-			names := make([]EventName, 0, len(c.handlers))
-			for n := range c.handlers {
-				names = append(names, n)
-			}
+	// Flag set by loop when receiving an error through the errors channel, to avoid an extra read
+	var receivedError bool
 
-			// Send the command and wait for FreeSWITCH to acknowledge the message
-			err = c.write(eventsSubscriptionCommand(names...)...)
-			expectOK(ECommandFailed)
+	// Start reading packets from FS and pumping them into the inbox channel. This process can be interrupted
+	// by closing the connection, then waiting on the `reading` channel for it to exit.
+	go c.read()
+
+	// This timeout will cover the handshake and event subscription.
+	h := &handshake{ctx: ctx, client: c, timeout: time.After(c.Timeout)}
+	greet(h)
+	c.subscribe(h)
+	c.restoreConfiguration(h)
+	err = h.err
+
+	// Begin normal operation
+	if err == nil {
+		c.setState(StateSubscribed, nil)
+		if begin != nil {
+			begin()
 		}
 
-		// Begin normal operation
-		if err == nil {
-
-			// Commands will wait in this queue to receive their responses
-			var cmdFiFo []*command
-
-			// Allow other goroutines to take control of the client
-			c.control.Unlock()
+		// Commands will wait in this queue to receive their responses
+		var cmdFiFo []*command
+
+		// Set while replies are being resynchronised with commands. See correlate().
+		var resyncTimeout <-chan time.Time
+
+		// Events will be passed to handlers according to c.Dispatch
+		dispatcher := c.newDispatcher()
+
+		// Allow other goroutines to take control of the client
+		c.control.Unlock()
+
+		// This is the normal operation loop
+		for err == nil {
+			select {
+
+			// This will break the loop
+			case err = <-c.errors:
+				receivedError = true
+
+			// Replies could not be resynchronised with commands
+			case <-resyncTimeout:
+				err = EOutOfSync
+
+			// We've received an inbound packet from FreeSWITCH
+			case inbound := <-c.inbox:
+				switch p := inbound.cast().(type) {
+				case *Event:
+					p.client = c
+					var handlers []*registeredHandler
+					exclusive(&c.control, func() {
+						handlers = c.handlers.match(p.Name())
+					})
+					dispatcher.dispatch(p, handlers)
+				case *disconnectNotice:
+					err = EDisconnected
+				default:
+					// Responses are matched to commands by their order, and checked. See correlate().
+					cmdFiFo, err = c.correlate(cmdFiFo, p)
+					resyncTimeout = c.resyncTimeout(cmdFiFo, resyncTimeout)
+				}
 
-			// This is the normal operation loop
-			for err == nil {
-				select {
+			// Commands will be sent by Execute(), Query() etc to this channel, singly or in batches. During
+			// connection and handshake, they'll block until here.
+			case cmds := <-c.outbox:
+				cmdFiFo = append(cmdFiFo, cmds...)
+				err = c.writeCommands(cmds)
+			}
+		}
 
-				// This will break the loop
-				case err = <-c.errors:
-					receivedError = true
-
-				// We've received an inbound packet from FreeSWITCH
-				case inbound := <-c.inbox:
-					switch p := inbound.cast().(type) {
-					case *Event:
-						p.client = c
-						var handlers []EventHandler
-						exclusive(&c.control, func() {
-							handlers = c.handlers[*p.Name()][:]
-						})
-						for _, handler := range handlers {
-							go handler(p) // Rely on handlers to recover from their own panics
-						}
-					case *disconnectNotice:
-						err = EDisconnected
-					default:
-						if len(cmdFiFo) > 0 {
-							cmd := cmdFiFo[0]
-							cmdFiFo = cmdFiFo[1:]
-							cmd.response <- p
-						}
-						// Discard other packets
-					}
+		// Take control back from other goroutines
+		c.control.Lock()
+		dispatcher.stop()
 
-				// Commands will be sent by Execute(), Query() etc to this channel. During connection and handshake,
-				// they'll block until here.
-				case cmd := <-c.outbox:
-					cmdFiFo = append(cmdFiFo, cmd)
-					err = c.write(cmd.command...)
+		// Unblock background jobs with empty responses
+		exclusive(&c.jobsLock, func() {
+			if len(c.jobs) > 0 {
+				for _, job := range c.jobs {
+					job.result <- ""
+					job.finish()
 				}
+				c.jobs = map[string]*backgroundJob{}
 			}
+		})
 
-			// Take control back from other goroutines
-			c.control.Lock()
+		// Channels and calls may end unseen while disconnected
+		if channels := c.channels.Load(); channels != nil {
+			channels.reset()
+		}
+		c.endCalls(ENotConnected)
 
-			// Unblock background jobs with empty responses
-			exclusive(&c.jobsLock, func() {
-				if len(c.jobs) > 0 {
-					for _, job := range c.jobs {
-						job <- ""
-					}
-					c.jobs = map[string]chan string{}
-				}
-			})
-
-			// Tell goroutines waiting to send commands that we're closed for the day
-			if c.FailOnDisconnect {
-				for done := false; !done; {
-					select {
-					case cmd := <-c.outbox:
-						cmd.response <- nil
-					default:
-						done = true
+		// Tell goroutines waiting to send commands that we're closed for the day
+		if c.FailOnDisconnect {
+			for done := false; !done; {
+				select {
+				case cmds := <-c.outbox:
+					for _, cmd := range cmds {
+						cmd.respond(nil)
 					}
+				default:
+					done = true
 				}
 			}
+		}
 
-			// Cancel pending commands that haven't yet received their responses
-			for _, cmd := range cmdFiFo {
-				cmd.response <- nil
+		// Cancel pending commands that haven't yet received their responses
+		for _, cmd := range cmdFiFo {
+			cmd.respond(nil)
+		}
+	}
+
+	// Close the connection
+	c.conn.Close()
+
+	// Wait for the read() goroutine to finish, discarding any packet or error it's still trying to deliver
+	for reading := true; reading; {
+		select {
+		case <-c.inbox:
+		case closeErr := <-c.errors:
+			receivedError = true
+			if closeErr == EShutdown {
+				err = closeErr
 			}
+		case <-c.reading:
+			reading = false
 		}
+	}
 
-		// Close the connection
-		c.conn.Close()
+	return c.stop(err, receivedError)
+}
 
-		// Wait for the read() goroutine to finish
-		<-c.reading
-	}
+// Flag the client as no longer running, and normalise the error with which a connection ended.
+func (c *Client) stop(err error, receivedError bool) error {
 
 	// There may also be an error trying to get into the error channel
 	if !c.setRunning(false) && !receivedError {
@@ -273,42 +433,102 @@ func (c *Client) Connect() (err error) {
 	if err == EShutdown {
 		err = nil
 	}
-	return
+	c.setState(StateDisconnected, err)
+	return err
+}
+
+// Wait for FreeSWITCH to request authentication and, when requested, send it a password.
+func (c *Client) authenticate(h *handshake) {
+	h.next(func(authPacket *rawPacket) {
+		if authPacket.packetType() == ptAuthRequest {
+			h.send("auth", c.Password)
+		} else {
+			h.err = EUnexpectedResponse
+		}
+	})
+
+	// Still within the handshake timeout, wait for an authentication response, and set an error if it fails.
+	h.expectOK(EAuthenticationFailed)
+	if h.err == nil {
+		c.setState(StateAuthenticated, nil)
+	}
+}
+
+// Listen to events for already-defined event handlers.
+func (c *Client) subscribe(h *handshake) {
+	if h.err == nil && len(c.handlers) > 0 {
+
+		// Send the command and wait for FreeSWITCH to acknowledge the message
+		h.send(eventsSubscriptionCommand(c.EventFormat, c.handlers.names()...)...)
+		h.expectOK(ECommandFailed)
+	}
 }
 
-// Shutdown will close the connection to FreeSWITCH and return from Connect().
+// Shutdown will close the connection to FreeSWITCH and return from Connect(), or stop Supervise() from reconnecting.
+// Once it has been called, Supervise() returns without connecting, so it also stops a Supervise() that hasn't started
+// yet.
 func (c *Client) Shutdown() {
+	exclusive(&c.haltLock, func() {
+		c.shutdown = true
+		if c.halt != nil {
+			c.halt()
+		}
+	})
 	c.close(EShutdown)
 	// No need to block here. Another connection attempt will wait for the control lock.
 }
 
 // Handle the given event with the given handler. It can be called multiple times to register multiple handlers, which
-// will be called simultaneously when an event fires. For CUSTOM events, use OnCustom() instead.
-func (c *Client) On(eventName string, handler EventHandler) {
-	c.on(EventName{eventName, ""}, handler)
+// will be called simultaneously when an event fires, unless the client's Dispatch options say otherwise. For CUSTOM
+// events, use OnCustom() instead.
+//
+// The returned function removes the handler. When the last handler for an event is removed, the client unsubscribes
+// from the event. It is safe to call more than once.
+func (c *Client) On(eventName string, handler EventHandler) (cancel func()) {
+	cancel, _ = c.on(EventName{eventName, ""}, handler)
+	return
+}
+
+// Handle custom events. See On() for details. The subclass may be a glob pattern, e.g. "sofia::*", in which case the
+// client subscribes to all events, since FreeSWITCH can only filter custom events by exact subclass.
+func (c *Client) OnCustom(eventSubclass string, handler EventHandler) (cancel func()) {
+	cancel, _ = c.on(EventName{"CUSTOM", eventSubclass}, handler)
+	return
 }
 
-// Handle custom events. See On() for details.
-func (c *Client) OnCustom(eventSubclass string, handler EventHandler) {
-	c.on(EventName{"CUSTOM", eventSubclass}, handler)
+// Handle every event. See On() for details.
+func (c *Client) OnAll(handler EventHandler) (cancel func()) {
+	cancel, _ = c.on(EventName{"ALL", ""}, handler)
+	return
+}
+
+// Handle several events with one handler. Names of custom events are given as "CUSTOM" followed by a space and the
+// subclass, e.g. "CUSTOM sofia::register", and may use glob patterns as with OnCustom(). The name "ALL" matches every
+// event. The returned function removes the handler from all of the given events. See On() for details.
+func (c *Client) OnMany(handler EventHandler, eventNames ...string) (cancel func()) {
+	var (
+		cancels = make([]func(), len(eventNames))
+		group   = &handlerGroup{}
+	)
+	for i, name := range eventNames {
+		cancels[i], _ = c.onGroup(parseEventName(name), group, handler)
+	}
+	return func() {
+		for _, cancel := range cancels {
+			cancel()
+		}
+	}
 }
 
 func (c *Client) sendEvent(e *Event) error {
 	be := *e
-	be.headers = e.headers[:]
+	be.headers = e.headers.clone()
 	be.headers.del("Event-Name")
-	result, err := c.execute([]string{"sendevent", e.Name().Name + "\n" + be.String()})
-	if err != nil {
-		return err
+	uuid, err := c.sendCommand("sendevent", e.Name().Name+"\n"+be.plainString())
+	if err == nil {
+		e.Set("Event-UUID", uuid)
 	}
-	if reply, ok := result.(*reply); ok {
-		if reply.ok() {
-			e.Set("Event-UUID", reply.text())
-			return nil
-		}
-		return errors.New(reply.text())
-	}
-	return EUnexpectedResponse
+	return err
 }
 
 // Execute runs an API command, and returns the response as a string.
@@ -323,19 +543,14 @@ func (c *Client) sendEvent(e *Event) error {
 // Internally, this method uses the "api" command. If PreventSocketBlocking is true, it will use "bgapi" instead, and
 // block until a response is received. Either way, its behaviour should be the same.
 func (c *Client) Execute(app string, args ...string) (result string, err error) {
-	if c.PreventSocketBlocking {
-		ch, err := c.Query(app, args...)
-		if err == nil {
-			result = <-ch
-		}
-	} else {
-		var p packet
-		p, err = c.execute(append([]string{"api", app}, args...))
-		if p != nil {
-			result = p.String()
-		}
-	}
-	return
+	return c.ExecuteContext(context.Background(), app, args...)
+}
+
+// ExecuteContext is the same as Execute(), but returns the context's error if the given context is done before a
+// response is received. The response to a cancelled command is discarded when it arrives.
+func (c *Client) ExecuteContext(ctx context.Context, app string, args ...string) (result string, err error) {
+	r := c.ExecuteBatchContext(ctx, RawCommand{app, args})[0]
+	return r.Result, r.Err
 }
 
 // Same as Execute(), but panics if an error occurs.
@@ -351,21 +566,44 @@ func (c *Client) MustExecute(app string, args ...string) string {
 //
 // See Execute(). This method is identical, but returns a channel through which the result will eventually be passed.
 // If the connection is interrupted or the command results in an error, an empty string will be sent through the
-// returned channel.
+// returned channel. If FreeSWITCH refuses to run the command at all, an error is returned instead.
 func (c *Client) Query(app string, args ...string) (result chan string, err error) {
-	var (
-		jobID = uniqueID()
-		cmd   = app + " " + strings.Join(args, " ") + "\nJob-UUID: " + jobID
-		p     packet
-	)
-	result = make(chan string, 1)
-	exclusive(&c.jobsLock, func() { c.jobs[jobID] = result })
-	p, err = c.execute([]string{"bgapi", cmd})
-	if p == nil {
-		result = nil
-		exclusive(&c.jobsLock, func() { delete(c.jobs, jobID) })
+	return c.QueryContext(context.Background(), app, args...)
+}
+
+// QueryContext is the same as Query(), but returns the context's error if the given context is done before the
+// command is accepted. If the context is done after the command is accepted, but before the job completes, the job
+// is abandoned, and an empty string is sent through the returned channel.
+func (c *Client) QueryContext(ctx context.Context, app string, args ...string) (result chan string, err error) {
+	job := c.QueryBatchContext(ctx, RawCommand{app, args})[0]
+	return job.Result, job.Err
+}
+
+// Make a bgapi command with a new Job-UUID.
+func newJobCommand(app string, args []string) *command {
+	jobID := uniqueID()
+	cmd := newCommand([]string{"bgapi", strings.Join(append([]string{app}, args...), " ") + "\nJob-UUID: " + jobID})
+	cmd.jobID = jobID
+	return cmd
+}
+
+// Abandon a background job when the given context is done, sending an empty string as its result.
+func (c *Client) abandonJobWhenDone(ctx context.Context, jobID string) {
+	stop := context.AfterFunc(ctx, func() {
+		if abandoned := c.removeJob(jobID); abandoned != nil {
+			abandoned <- ""
+		}
+	})
+	// Kept with the job, so that a long-lived context doesn't accumulate the registrations of completed jobs
+	var job *backgroundJob
+	exclusive(&c.jobsLock, func() {
+		if job = c.jobs[jobID]; job != nil {
+			job.stop = stop
+		}
+	})
+	if job == nil {
+		stop()
 	}
-	return
 }
 
 // Same as Query(), but panics if an error occurs.
@@ -381,7 +619,7 @@ func (c *Client) MustQuery(app string, args ...string) chan string {
 func (c *Client) Event(name string) *Event {
 	return &Event{
 		client:  c,
-		headers: headers{{"Event-Name", name}},
+		headers: makeHeaders(header{"Event-Name", name}),
 	}
 }
 
@@ -390,64 +628,175 @@ func (c *Client) CustomEvent(subclass string) *Event {
 	return c.Event("CUSTOM").Set("Event-Subclass", subclass)
 }
 
-// Load an event from a raw FreeSWITCH packet. This complements the String method of the Event type, which exports
-// events to a format that can be read by this method.
+// Load an event from a raw FreeSWITCH packet, in any supported EventFormat. This complements the String method of
+// the Event type, which exports events to a format that can be read by this method.
 func (c *Client) LoadEvent(packet string) *Event {
 	return &Event{
 		client:    c,
 		rawPacket: &rawPacket{body: packet},
+		format:    detectEventFormat(packet),
 	}
 }
 
 func (c *Client) execute(args []string) (result packet, err error) {
-	cmd := &command{
-		command:  args,
-		response: make(chan packet),
+	return c.executeContext(context.Background(), args)
+}
+
+func (c *Client) executeContext(ctx context.Context, args []string) (result packet, err error) {
+	return c.executeCommand(ctx, newCommand(args))
+}
+
+func (c *Client) executeCommand(ctx context.Context, cmd *command) (result packet, err error) {
+	timeout := time.NewTimer(c.Timeout)
+	defer timeout.Stop()
+	if err = c.enqueue(ctx, timeout.C, cmd); err == nil {
+		result, err = cmd.wait(ctx)
 	}
+	return
+}
+
+// Pass commands to the connection to be sent together, waiting until the timeout for it to accept them.
+func (c *Client) enqueue(ctx context.Context, timeout <-chan time.Time, cmds ...*command) error {
 	select {
-	case c.outbox <- cmd:
-		result = <-cmd.response
-		if result == nil {
-			err = ENotConnected
-		}
-	case <-time.After(c.Timeout):
-		err = ETimeout
+	case c.outbox <- cmds:
+		return nil
+	case <-timeout:
+		return ETimeout
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.ended:
+		return ENotConnected
+	}
+}
+
+// Register a background job, so that its result is sent to the given channel. The release function is called when
+// the job is removed.
+func (c *Client) addJob(jobID string, result chan string, release func()) {
+	exclusive(&c.jobsLock, func() { c.jobs[jobID] = &backgroundJob{result: result, release: release} })
+}
+
+// Remove a background job, returning its result channel, or nil if the job has already completed.
+func (c *Client) removeJob(jobID string) (resultChan chan string) {
+	var job *backgroundJob
+	exclusive(&c.jobsLock, func() {
+		job = c.jobs[jobID]
+		delete(c.jobs, jobID)
+	})
+	if job != nil {
+		job.finish()
+		resultChan = job.result
 	}
 	return
 }
 
+// Send a command to which FreeSWITCH will respond with a command/reply, and return the reply's text. If the reply is
+// not "+OK", its text is returned as an error.
+func (c *Client) sendCommand(args ...string) (text string, err error) {
+	result, err := c.execute(args)
+	if err != nil {
+		return
+	}
+	if reply, ok := result.(*reply); ok {
+		if reply.ok() {
+			return reply.text(), nil
+		}
+		return "", errors.New(reply.text())
+	}
+	return "", EUnexpectedResponse
+}
+
 func (c *Client) bgJobDone(e *Event) {
 	var (
 		resultChan chan string
 		jobID      = e.Get("Job-UUID")
 	)
 	if jobID != "" {
-		exclusive(&c.jobsLock, func() {
-			resultChan = c.jobs[jobID]
-			delete(c.jobs, jobID)
-		})
-		if resultChan != nil {
+		if resultChan = c.removeJob(jobID); resultChan != nil {
 			resultChan <- e.Body()
 		}
 	}
 }
 
 func (c *Client) write(cmd ...string) (err error) {
+	_, err = c.conn.Write(c.appendCommand(nil, cmd))
+	return
+}
+
+// Write several commands to the connection at once.
+func (c *Client) writeCommands(cmds []*command) (err error) {
+	var buf []byte
+	for _, cmd := range cmds {
+		buf = c.appendCommand(buf, cmd.command)
+	}
+	_, err = c.conn.Write(buf)
+	return
+}
+
+// Log a command, and append it to buf as it is written to the connection.
+func (c *Client) appendCommand(buf []byte, cmd []string) []byte {
 	joined := strings.Join(cmd, " ")
 	c.log(joined, true)
-	_, err = c.conn.Write(append([]byte(joined), '\n', '\n'))
-	return
+	return append(append(buf, joined...), '\n', '\n')
 }
 
-func (c *Client) on(name EventName, handler EventHandler) (err error) {
+func (c *Client) on(name EventName, handler EventHandler) (cancel func(), err error) {
+	return c.onGroup(name, &handlerGroup{}, handler)
+}
+
+// Register a handler, and subscribe to its event if not already subscribed. The returned error is that of the
+// subscription, if one was made; the handler is registered regardless, and the subscription is retried on reconnection.
+func (c *Client) onGroup(name EventName, group *handlerGroup, handler EventHandler) (cancel func(), err error) {
+	registered := &registeredHandler{handler: handler, group: group}
+	atomic.AddInt32(&group.active, 1)
 	c.control.Lock()
-	alreadyHandled := len(c.handlers[name]) > 0
-	c.handlers[name] = append(c.handlers[name], handler)
+	alreadySubscribed := len(c.handlers[name]) > 0 || c.handlers.needsAll()
+	c.handlers[name] = append(c.handlers[name], registered)
 	c.control.Unlock()
-	if c.isRunning() && !alreadyHandled {
-		_, err = c.execute(eventsSubscriptionCommand(name))
+	if c.isRunning() && !alreadySubscribed {
+		_, err = c.sendCommand(eventsSubscriptionCommand(c.EventFormat, name)...)
+	}
+	var once sync.Once
+	return func() { once.Do(func() { c.off(name, registered) }) }, err
+}
+
+// Remove a handler, and unsubscribe from its event if no handlers remain. The client's own BACKGROUND_JOB handler is
+// never removed, so there's always at least one subscription.
+func (c *Client) off(name EventName, registered *registeredHandler) {
+	var unsubscribe [][]string
+	atomic.StoreInt32(&registered.removed, 1)
+	atomic.AddInt32(&registered.group.active, -1)
+	exclusive(&c.control, func() {
+		existing := c.handlers[name]
+		remaining := make([]*registeredHandler, 0, len(existing))
+		for _, h := range existing {
+			if h != registered {
+				remaining = append(remaining, h)
+			}
+		}
+		if len(remaining) > 0 {
+			c.handlers[name] = remaining
+			return
+		} else if len(existing) == 0 {
+			return
+		}
+		delete(c.handlers, name)
+		switch {
+		case c.handlers.needsAll():
+			// Still subscribed to everything.
+		case name.isWildcard():
+			// Starting again with "noevents" would lose events FreeSWITCH has already queued, including results of
+			// background jobs, so nix only what's no longer needed. Subscribing first restores custom subclasses.
+			names := c.handlers.names()
+			unsubscribe = [][]string{eventsSubscriptionCommand(c.EventFormat, names...), nixUnneededEventsCommand(names)}
+		default:
+			unsubscribe = [][]string{append([]string{"nixevent"}, eventNameArgs([]EventName{name})...)}
+		}
+	})
+	if c.isRunning() {
+		for _, cmd := range unsubscribe {
+			c.execute(cmd)
+		}
 	}
-	return
 }
 
 func (c *Client) close(err error) {
@@ -470,24 +819,15 @@ func (c *Client) isRunning() bool {
 
 func (c *Client) read() {
 	var (
-		mainReader    = bufio.NewReader(c.conn)
-		mimeReader    = textproto.NewReader(mainReader)
-		err           error
-		headers       textproto.MIMEHeader
-		contentLength int
+		reader = newPacketReader(c.conn)
+		p      *rawPacket
+		err    error
 	)
 	for err == nil {
-		if headers, err = mimeReader.ReadMIMEHeader(); err == nil {
-			p := &rawPacket{headers: loadHeaders(headers, false)}
-			if contentLengthStr := p.headers.get("Content-Length"); contentLengthStr != "" {
-				if contentLength, err = strconv.Atoi(contentLengthStr); err == nil && contentLength > 0 {
-					body := make([]byte, contentLength)
-					if _, err = io.ReadFull(mainReader, body); err == nil {
-						p.body = string(body)
-					}
-				}
+		if p, err = reader.next(); err == nil {
+			if c.Logger != nil {
+				c.log(p.String(), false)
 			}
-			c.log(p.String(), false)
 			c.inbox <- p
 		}
 	}
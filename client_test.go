@@ -1,9 +1,16 @@
 package freeswitch_test
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/hx/freeswitch"
+	"github.com/hx/freeswitch/esltest"
 	"os/exec"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"testing"
 	"time"
 )
 
@@ -23,18 +30,24 @@ func ExampleNewClient_simple() {
 	// Output: +OK [Success]
 }
 
-// This example tries to persist the connection after failures.
+// This example persists the connection after failures.
 func ExampleNewClient_persistent() {
 	// Make a new client, guessing its configuration
 	c := freeswitch.NewClient()
 
+	// Report disconnections
+	c.OnStateChange = func(state freeswitch.ConnectionState, err error) {
+		if state == freeswitch.StateDisconnected && err != nil {
+			fmt.Printf("Disconnected: %s; trying again shortly\n", err)
+		}
+	}
+
 	// Connect to FreeSWITCH, and as long as connections terminate abnormally, keep reconnecting:
+	done := make(chan struct{})
 	go func() {
-		for err := c.Connect(); err != nil; {
-			fmt.Printf("Disconnected: %s; trying again in 5 seconds", err)
-			time.Sleep(5 * time.Second)
-		}
+		c.Supervise()
 		fmt.Println("Termination was graceful.")
+		close(done)
 	}()
 
 	// Let the connection run a while
@@ -48,7 +61,8 @@ func ExampleNewClient_persistent() {
 
 	// Shut down normally
 	c.Shutdown()
-	// Output: Disconnected: EOF; trying again in 5 seconds
+	<-done
+	// Output: Disconnected: EOF; trying again shortly
 	// Termination was graceful.
 }
 
@@ -56,7 +70,7 @@ func ExampleNewClient_persistent() {
 //
 // Because Connect() blocks until disconnection, intended or otherwise, there's no clear indication that
 // connection and handshaking has completed. However, Execute() blocks until the client is ready to send commands,
-// so a simple no-op can guide your way.
+// so a simple no-op can guide your way. See also WaitReady().
 func ExampleClient_Execute() {
 	// Make a new client, guessing its configuration
 	c := freeswitch.NewClient()
@@ -74,3 +88,1413 @@ func ExampleClient_Execute() {
 	c.Shutdown()
 	// Output: +OK [Success]
 }
+
+// This example shows waiting for connection and handshaking to complete without running a command.
+func ExampleClient_WaitReady() {
+	// Make a new client, guessing its configuration
+	c := freeswitch.NewClient()
+
+	// Open a connection, with no regard to how it terminates
+	go c.Connect()
+
+	// Wait for the connection to succeed
+	if err := c.WaitReady(context.Background()); err != nil {
+		panic(err)
+	}
+
+	// We can now assume we are connected, and go about our business
+	fmt.Println(c.Execute("reloadxml"))
+	c.Shutdown()
+	// Output: +OK [Success]
+}
+
+func newTestClient(fs *esltest.Server) *freeswitch.Client {
+	c := freeswitch.NewClient()
+	c.Hostname = fs.Host()
+	c.Port = fs.Port()
+	c.Password = fs.Password
+	c.Timeout = time.Second
+	return c
+}
+
+func TestClient_Execute(t *testing.T) {
+	fs := esltest.NewServer()
+	defer fs.Close()
+	fs.RespondAPI("status", "UP 0 years, 0 days\n")
+
+	c := newTestClient(fs)
+	done := make(chan error)
+	go func() { done <- c.Connect() }()
+
+	result, err := c.Execute("status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != "UP 0 years, 0 days\n" {
+		t.Errorf("unexpected result %q", result)
+	}
+	expectCommands(t, fs, "auth ClueCon", "events plain BACKGROUND_JOB", "api status")
+
+	c.Shutdown()
+	if err := <-done; err != nil {
+		t.Errorf("expected graceful shutdown, got %s", err)
+	}
+}
+
+func TestClient_Query(t *testing.T) {
+	fs := esltest.NewServer()
+	defer fs.Close()
+	fs.HandleAPI("echo", func(args string) string { return args })
+
+	c := newTestClient(fs)
+	go c.Connect()
+	defer c.Shutdown()
+
+	result, err := c.Query("echo", "hello", "world")
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case body := <-result:
+		if body != "hello world" {
+			t.Errorf("unexpected result %q", body)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for background job")
+	}
+}
+
+func TestClient_On(t *testing.T) {
+	fs := esltest.NewServer()
+	defer fs.Close()
+
+	var (
+		c      = newTestClient(fs)
+		events = make(chan *freeswitch.Event)
+	)
+	c.On("CHANNEL_ANSWER", func(e *freeswitch.Event) { events <- e })
+	c.OnCustom("sofia::register", func(e *freeswitch.Event) { events <- e })
+	go c.Connect()
+	defer c.Shutdown()
+
+	if _, err := c.Execute("status"); err != nil {
+		t.Fatal(err)
+	}
+	expectCommands(t, fs, "auth ClueCon", "events plain BACKGROUND_JOB CHANNEL_ANSWER CUSTOM sofia::register")
+
+	fs.Emit(esltest.NewEvent("CHANNEL_HANGUP"))
+	fs.Emit(esltest.NewEvent("CHANNEL_ANSWER", "Unique-ID", "abc", "Caller-Caller-ID-Name", "Jo Bloggs"))
+	if e := <-events; e.Get("Unique-ID") != "abc" || e.Get("Caller-Caller-ID-Name") != "Jo Bloggs" {
+		t.Errorf("unexpected event %s", e)
+	}
+
+	fs.Emit(esltest.NewCustomEvent("sofia::register", "from-user", "1000"))
+	if e := <-events; e.Name().Subclass != "sofia::register" || e.Get("from-user") != "1000" {
+		t.Errorf("unexpected event %s", e)
+	}
+}
+
+func TestClient_On_cancel(t *testing.T) {
+	fs := esltest.NewServer()
+	defer fs.Close()
+
+	var (
+		c      = newTestClient(fs)
+		events = make(chan string, 10)
+	)
+	cancelFirst := c.On("CHANNEL_ANSWER", func(e *freeswitch.Event) { events <- "first" })
+	cancelSecond := c.On("CHANNEL_ANSWER", func(e *freeswitch.Event) { events <- "second" })
+	cancelCustom := c.OnCustom("sofia::register", func(e *freeswitch.Event) { events <- "custom" })
+	go c.Connect()
+	defer c.Shutdown()
+
+	if _, err := c.Execute("status"); err != nil {
+		t.Fatal(err)
+	}
+	expectCommands(t, fs, "auth ClueCon", "events plain BACKGROUND_JOB CHANNEL_ANSWER CUSTOM sofia::register",
+		"api status")
+
+	cancelFirst()
+	cancelFirst()
+	fs.Emit(esltest.NewEvent("CHANNEL_ANSWER"))
+	if handler := <-events; handler != "second" {
+		t.Errorf("unexpected handler %s", handler)
+	}
+
+	cancelSecond()
+	expectCommands(t, fs, "nixevent CHANNEL_ANSWER")
+	cancelCustom()
+	expectCommands(t, fs, "nixevent CUSTOM sofia::register")
+
+	fs.Emit(esltest.NewEvent("CHANNEL_ANSWER"))
+	fs.Emit(esltest.NewCustomEvent("sofia::register"))
+	if _, err := c.Execute("status"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case handler := <-events:
+		t.Errorf("unexpected handler %s", handler)
+	default:
+	}
+}
+
+func TestClient_Connect_authenticationFailed(t *testing.T) {
+	fs := esltest.NewServer()
+	defer fs.Close()
+
+	c := newTestClient(fs)
+	c.Password = "wrong"
+	if err := c.Connect(); err != freeswitch.EAuthenticationFailed {
+		t.Errorf("expected authentication failure, got %v", err)
+	}
+}
+
+func TestClient_Connect_disconnected(t *testing.T) {
+	fs := esltest.NewServer()
+	defer fs.Close()
+
+	c := newTestClient(fs)
+	done := make(chan error)
+	go func() { done <- c.Connect() }()
+	if _, err := c.Execute("status"); err != nil {
+		t.Fatal(err)
+	}
+
+	fs.Disconnect()
+	if err := <-done; err != freeswitch.EDisconnected {
+		t.Errorf("expected disconnection, got %v", err)
+	}
+}
+
+func expectCommands(t *testing.T, fs *esltest.Server, expected ...string) {
+	t.Helper()
+	for _, exp := range expected {
+		if cmd, ok := fs.Next(); !ok {
+			t.Fatalf("expected %q, got nothing", exp)
+		} else if cmd != exp {
+			t.Fatalf("expected %q, got %q", exp, cmd)
+		}
+	}
+}
+
+func TestClient_ExecuteContext(t *testing.T) {
+	fs := esltest.NewServer()
+	defer fs.Close()
+
+	release := make(chan struct{})
+	fs.HandleAPI("slow", func(string) string {
+		<-release
+		return "slow result"
+	})
+	fs.RespondAPI("fast", "fast result")
+
+	c := newTestClient(fs)
+	go c.Connect()
+	defer c.Shutdown()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.ExecuteContext(ctx, "slow"); err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline to be exceeded, got %v", err)
+	}
+	close(release)
+
+	// The abandoned command's response must not be delivered to the next command.
+	if result, err := c.Execute("fast"); err != nil || result != "fast result" {
+		t.Errorf("unexpected result %q, %v", result, err)
+	}
+}
+
+func TestClient_ExecuteContext_preventSocketBlocking(t *testing.T) {
+	fs := esltest.NewServer()
+	defer fs.Close()
+
+	release := make(chan struct{})
+	defer close(release)
+	fs.HandleAPI("slow", func(string) string {
+		<-release
+		return "slow result"
+	})
+
+	cancels := make(chan func(), 1)
+	c := newTestClient(fs)
+	c.PreventSocketBlocking = true
+	c.Logger = func(packet string, isOutbound bool) {
+		// Cancel each command's context around when its job is accepted
+		if !isOutbound && strings.Contains(packet, "Job-UUID") {
+			(<-cancels)()
+		}
+	}
+	go c.Connect()
+	defer c.Shutdown()
+
+	// The abandoned job's empty result must never be returned in place of the context's error.
+	for i := 0; i < 200; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		delay := time.Duration(i%50) * time.Microsecond
+		cancels <- func() { time.AfterFunc(delay, cancel) }
+		if result, err := c.ExecuteContext(ctx, "slow"); err != context.Canceled {
+			t.Fatalf("expected context to be cancelled, got %q, %v", result, err)
+		}
+	}
+}
+
+func TestClient_QueryContext(t *testing.T) {
+	fs := esltest.NewServer()
+	defer fs.Close()
+
+	release := make(chan struct{})
+	defer close(release)
+	fs.HandleAPI("slow", func(string) string {
+		<-release
+		return "slow result"
+	})
+
+	c := newTestClient(fs)
+	go c.Connect()
+	defer c.Shutdown()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result, err := c.QueryContext(ctx, "slow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if body := <-result; body != "" {
+		t.Errorf("expected empty result from abandoned job, got %q", body)
+	}
+}
+
+func TestClient_ConnectContext(t *testing.T) {
+	fs := esltest.NewServer()
+	defer fs.Close()
+
+	var (
+		c           = newTestClient(fs)
+		ctx, cancel = context.WithCancel(context.Background())
+		done        = make(chan error)
+	)
+	go func() { done <- c.ConnectContext(ctx) }()
+	if _, err := c.Execute("status"); err != nil {
+		t.Fatal(err)
+	}
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("expected cancellation, got %v", err)
+	}
+}
+
+func TestClient_Supervise(t *testing.T) {
+	fs := esltest.NewServer()
+	defer fs.Close()
+
+	var (
+		c      = newTestClient(fs)
+		states = make(chan freeswitch.ConnectionState, 16)
+		done   = make(chan error)
+	)
+	c.Backoff = freeswitch.Backoff{Initial: 10 * time.Millisecond, Jitter: -1}
+	c.OnStateChange = func(state freeswitch.ConnectionState, err error) { states <- state }
+	c.On("HEARTBEAT", func(*freeswitch.Event) {})
+	go func() { done <- c.Supervise() }()
+
+	expectStates := func(expected ...freeswitch.ConnectionState) {
+		t.Helper()
+		for _, exp := range expected {
+			select {
+			case state := <-states:
+				if state != exp {
+					t.Fatalf("expected state %s, got %s", exp, state)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("timed out waiting for state %s", exp)
+			}
+		}
+	}
+
+	expectStates(freeswitch.StateConnecting, freeswitch.StateAuthenticated, freeswitch.StateSubscribed)
+	expectCommands(t, fs, "auth ClueCon", "events plain BACKGROUND_JOB HEARTBEAT")
+
+	fs.Drop()
+	expectStates(freeswitch.StateDisconnected,
+		freeswitch.StateConnecting, freeswitch.StateAuthenticated, freeswitch.StateSubscribed)
+	expectCommands(t, fs, "auth ClueCon", "events plain BACKGROUND_JOB HEARTBEAT")
+
+	c.Shutdown()
+	expectStates(freeswitch.StateDisconnected)
+	if err := <-done; err != nil {
+		t.Errorf("expected graceful shutdown, got %s", err)
+	}
+}
+
+func TestClient_Supervise_shutdownFirst(t *testing.T) {
+	fs := esltest.NewServer()
+	defer fs.Close()
+
+	c := newTestClient(fs)
+	c.Shutdown()
+	done := make(chan error)
+	go func() { done <- c.Supervise() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("expected graceful shutdown, got %s", err)
+		}
+	case <-time.After(time.Second):
+		c.Shutdown()
+		t.Fatal("expected Supervise() not to connect after Shutdown()")
+	}
+}
+
+func TestClient_WaitReady(t *testing.T) {
+	fs := esltest.NewServer()
+	defer fs.Close()
+
+	c := newTestClient(fs)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := c.WaitReady(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline to be exceeded before connecting, got %v", err)
+	}
+
+	done := make(chan error)
+	go func() { done <- c.Connect() }()
+	if err := c.WaitReady(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if state := c.State(); state != freeswitch.StateSubscribed {
+		t.Errorf("expected subscribed state, got %s", state)
+	}
+
+	fs.Drop()
+	<-done
+	select {
+	case <-c.Ready():
+		t.Error("expected client not to be ready after disconnection")
+	default:
+	}
+}
+
+func TestClient_EventFormat_json(t *testing.T) {
+	fs := esltest.NewServer()
+	defer fs.Close()
+
+	var (
+		c      = newTestClient(fs)
+		events = make(chan *freeswitch.Event)
+	)
+	c.EventFormat = freeswitch.EventFormatJSON
+	c.On("CHANNEL_ANSWER", func(e *freeswitch.Event) { events <- e })
+	go c.Connect()
+	defer c.Shutdown()
+
+	if err := c.WaitReady(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	expectCommands(t, fs, "auth ClueCon", "events json BACKGROUND_JOB CHANNEL_ANSWER")
+
+	fs.Emit(esltest.NewEvent("CHANNEL_ANSWER", "Unique-ID", "abc").SetBody("some body"))
+	e := <-events
+	if e.Format() != freeswitch.EventFormatJSON || e.Get("Unique-ID") != "abc" || e.Body() != "some body" {
+		t.Errorf("unexpected event %s", e)
+	}
+}
+
+func TestClient_EventFormat_xml(t *testing.T) {
+	fs := esltest.NewServer()
+	defer fs.Close()
+
+	var (
+		c      = newTestClient(fs)
+		events = make(chan *freeswitch.Event)
+	)
+	c.EventFormat = freeswitch.EventFormatXML
+	c.On("CHANNEL_ANSWER", func(e *freeswitch.Event) { events <- e })
+	go c.Connect()
+	defer c.Shutdown()
+
+	if err := c.WaitReady(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	expectCommands(t, fs, "auth ClueCon", "events xml BACKGROUND_JOB CHANNEL_ANSWER")
+
+	fs.Emit(esltest.NewEvent("CHANNEL_ANSWER", "Unique-ID", "abc", "Caller-Caller-ID-Name", "<Jo>"))
+	e := <-events
+	if e.Format() != freeswitch.EventFormatXML || e.Get("Unique-ID") != "abc" || e.Get("Caller-Caller-ID-Name") != "<Jo>" {
+		t.Errorf("unexpected event %s", e)
+	}
+}
+
+func TestClient_Run(t *testing.T) {
+	fs := esltest.NewServer()
+	defer fs.Close()
+	fs.RespondAPI("uuid_kill", "-ERR No such channel!\n")
+	fs.RespondAPI("uuid_bridge", "+OK a1b2c3\n")
+
+	c := newTestClient(fs)
+	go c.Connect()
+	defer c.Shutdown()
+
+	if _, err := c.Run(freeswitch.UUIDKill{UUID: "a1b2c3", Cause: "USER_BUSY"}); err == nil ||
+		err.Error() != "uuid_kill: No such channel!" {
+		t.Errorf("expected error, got %v", err)
+	}
+	if result, err := c.Run(freeswitch.UUIDBridge{UUID: "a1b2c3", OtherUUID: "d4e5f6"}); err != nil ||
+		result != "a1b2c3" {
+		t.Errorf("unexpected result %q, %v", result, err)
+	}
+	expectCommands(t, fs, "auth ClueCon", "events plain BACKGROUND_JOB",
+		"api uuid_kill a1b2c3 USER_BUSY", "api uuid_bridge a1b2c3 d4e5f6")
+}
+
+func TestClient_Originate(t *testing.T) {
+	fs := esltest.NewServer()
+	defer fs.Close()
+	fs.HandleAPI("originate", func(args string) string {
+		uuid := regexp.MustCompile(`origination_uuid=([^,}]+)`).FindStringSubmatch(args)[1]
+		if strings.Contains(args, "user/busy") {
+			return "-ERR USER_BUSY\n"
+		}
+		fs.Emit(esltest.NewEvent("CHANNEL_ANSWER", "Unique-ID", uuid))
+		fs.Emit(esltest.NewEvent("CHANNEL_HANGUP_COMPLETE", "Unique-ID", uuid, "Hangup-Cause", "NORMAL_CLEARING"))
+		return "+OK " + uuid + "\n"
+	})
+
+	c := newTestClient(fs)
+	go c.Connect()
+	defer c.Shutdown()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	call, err := c.Originate(freeswitch.Originate{
+		Legs:        []freeswitch.Leg{{Endpoint: "user/1000"}},
+		Destination: "&park()",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, err := call.WaitAnswer(ctx); err != nil || e.Get("Unique-ID") != call.UUID {
+		t.Fatalf("unexpected answer %v, %v", e, err)
+	}
+	if e, err := call.WaitHangup(ctx); err != nil || e.Get("Hangup-Cause") != "NORMAL_CLEARING" {
+		t.Fatalf("unexpected hangup %v, %v", e, err)
+	}
+
+	busy, err := c.Originate(freeswitch.Originate{
+		Legs:        []freeswitch.Leg{{Endpoint: "user/busy"}},
+		Destination: "&park()",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var apiErr *freeswitch.APIError
+	if _, err := busy.WaitAnswer(ctx); !errors.As(err, &apiErr) || apiErr.Cause != "USER_BUSY" {
+		t.Errorf("expected USER_BUSY, got %v", err)
+	}
+}
+
+func TestClient_Originate_abandoned(t *testing.T) {
+	fs := esltest.NewServer()
+	defer fs.Close()
+	release := make(chan struct{})
+	defer close(release)
+	fs.HandleAPI("originate", func(args string) string {
+		<-release
+		return "+OK\n"
+	})
+
+	c := newTestClient(fs)
+	go c.Connect()
+	defer c.Shutdown()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	call, err := c.OriginateContext(ctx, freeswitch.Originate{
+		Legs:        []freeswitch.Leg{{Endpoint: "user/1000"}},
+		Destination: "&park()",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+
+	wait, stop := context.WithTimeout(context.Background(), time.Second)
+	defer stop()
+	if _, err := call.WaitHangup(wait); err != context.Canceled {
+		t.Errorf("expected abandoned call to end, got %v", err)
+	}
+}
+
+func TestClient_Originate_disconnected(t *testing.T) {
+	fs := esltest.NewServer()
+	defer fs.Close()
+	fs.HandleAPI("originate", func(args string) string {
+		uuid := regexp.MustCompile(`origination_uuid=([^,}]+)`).FindStringSubmatch(args)[1]
+		fs.Emit(esltest.NewEvent("CHANNEL_ANSWER", "Unique-ID", uuid))
+		return "+OK " + uuid + "\n"
+	})
+
+	c := newTestClient(fs)
+	done := make(chan error)
+	go func() { done <- c.Connect() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	call, err := c.Originate(freeswitch.Originate{
+		Legs:        []freeswitch.Leg{{Endpoint: "user/1000"}},
+		Destination: "&park()",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := call.WaitAnswer(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for c.BgAPILimitStats().InFlight > 0 {
+		time.Sleep(time.Millisecond) // until the originate job completes
+	}
+
+	// The call's hangup can't be seen once disconnected, so the handle ends.
+	fs.Drop()
+	<-done
+	if _, err := call.WaitHangup(ctx); err != freeswitch.ENotConnected {
+		t.Errorf("expected call to end on disconnection, got %v", err)
+	}
+}
+
+func TestClient_CheckResults(t *testing.T) {
+	fs := esltest.NewServer()
+	defer fs.Close()
+	fs.RespondAPI("uuid_kill", "-ERR No such channel!\n")
+
+	for _, preventSocketBlocking := range []bool{false, true} {
+		c := newTestClient(fs)
+		c.CheckResults = true
+		c.PreventSocketBlocking = preventSocketBlocking
+		go c.Connect()
+
+		result, err := c.Execute("uuid_kill", "a1b2c3")
+		var apiErr *freeswitch.APIError
+		if !errors.As(err, &apiErr) || apiErr.Cause != "No such channel!" || apiErr.Command != "uuid_kill" {
+			t.Errorf("expected API error, got %v", err)
+		}
+		if result != "-ERR No such channel!\n" {
+			t.Errorf("expected result to be returned with error, got %q", result)
+		}
+		c.Shutdown()
+	}
+}
+
+func TestClient_TrackChannels(t *testing.T) {
+	fs := esltest.NewServer()
+	defer fs.Close()
+
+	c := newTestClient(fs)
+	channels := c.TrackChannels()
+	if c.TrackChannels() != channels {
+		t.Error("expected the same registry")
+	}
+	changes := make(chan freeswitch.Channel, 10)
+	channels.OnStateChange(func(channel freeswitch.Channel, previous freeswitch.ChannelState) {
+		changes <- channel
+	})
+	go c.Connect()
+	defer c.Shutdown()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := c.WaitReady(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	const a, b = "a-leg", "b-leg"
+	expect := func(e *esltest.Event, uuid string, state freeswitch.ChannelState) freeswitch.Channel {
+		t.Helper()
+		if e != nil {
+			fs.Emit(e)
+		}
+		select {
+		case channel := <-changes:
+			if channel.UUID != uuid || channel.State != state {
+				t.Fatalf("expected %s to be %s, got %s %s", uuid, state, channel.UUID, channel.State)
+			}
+			return channel
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for %s to be %s", uuid, state)
+		}
+		return freeswitch.Channel{}
+	}
+
+	expect(esltest.NewEvent("CHANNEL_PROGRESS", "Unique-ID", a), a, freeswitch.ChannelRinging)
+	expect(esltest.NewEvent("CHANNEL_ANSWER", "Unique-ID", a), a, freeswitch.ChannelAnswered)
+	expect(esltest.NewEvent("CHANNEL_CREATE", "Unique-ID", b), b, freeswitch.ChannelCreated)
+	fs.Emit(esltest.NewEvent("CHANNEL_BRIDGE", "Bridge-A-Unique-ID", a, "Bridge-B-Unique-ID", b))
+	if channel := expect(nil, a, freeswitch.ChannelBridged); channel.BridgedTo != b {
+		t.Errorf("expected %s to be bridged to %s, got %q", a, b, channel.BridgedTo)
+	}
+	expect(nil, b, freeswitch.ChannelBridged)
+	expect(esltest.NewEvent("CHANNEL_HOLD", "Unique-ID", b), b, freeswitch.ChannelHeld)
+	expect(esltest.NewEvent("CHANNEL_UNHOLD", "Unique-ID", b), b, freeswitch.ChannelBridged)
+
+	if channel, ok := channels.Get(a); !ok || channel.State != freeswitch.ChannelBridged {
+		t.Errorf("unexpected channel %v", channel)
+	}
+	if count := len(channels.All()); count != 2 {
+		t.Errorf("expected 2 channels, got %d", count)
+	}
+
+	expect(esltest.NewEvent("CHANNEL_HANGUP_COMPLETE", "Unique-ID", a), a, freeswitch.ChannelHungUp)
+	if _, ok := channels.Get(a); ok {
+		t.Error("expected hung up channel to be removed")
+	}
+
+	// Late events for hung up channels are ignored.
+	fs.Emit(esltest.NewEvent("CHANNEL_ANSWER", "Unique-ID", a))
+	expect(esltest.NewEvent("CHANNEL_HANGUP", "Unique-ID", b), b, freeswitch.ChannelHungUp)
+	if count := channels.Len(); count != 1 {
+		t.Errorf("expected 1 channel, got %d", count)
+	}
+}
+
+func TestClient_TrackChannels_reconnect(t *testing.T) {
+	fs := esltest.NewServer()
+	defer fs.Close()
+
+	c := newTestClient(fs)
+	c.Backoff = freeswitch.Backoff{Initial: 10 * time.Millisecond, Jitter: -1}
+	disconnected := make(chan struct{}, 10)
+	c.OnStateChange = func(state freeswitch.ConnectionState, err error) {
+		if state == freeswitch.StateDisconnected {
+			disconnected <- struct{}{}
+		}
+	}
+	channels := c.TrackChannels()
+	changes := make(chan freeswitch.Channel, 10)
+	channels.OnStateChange(func(channel freeswitch.Channel, previous freeswitch.ChannelState) {
+		changes <- channel
+	})
+	done := make(chan error)
+	go func() { done <- c.Supervise() }()
+	defer func() {
+		c.Shutdown()
+		<-done
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	expect := func(e *esltest.Event, state freeswitch.ChannelState) {
+		t.Helper()
+		fs.Emit(e)
+		select {
+		case channel := <-changes:
+			if channel.State != state {
+				t.Fatalf("expected %s, got %s", state, channel.State)
+			}
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for %s", state)
+		}
+	}
+
+	if err := c.WaitReady(ctx); err != nil {
+		t.Fatal(err)
+	}
+	expect(esltest.NewEvent("CHANNEL_ANSWER", "Unique-ID", "a-leg", "Event-Sequence", "100"), freeswitch.ChannelAnswered)
+	expect(esltest.NewEvent("CHANNEL_HANGUP_COMPLETE", "Unique-ID", "b-leg", "Event-Sequence", "101"),
+		freeswitch.ChannelHungUp)
+
+	// Channels that hung up while disconnected aren't left behind, and a restarted FreeSWITCH's sequence, and UUIDs
+	// remembered from before, don't stop new events from being applied.
+	fs.Drop()
+	<-disconnected
+	if err := c.WaitReady(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if count := channels.Len(); count != 0 {
+		t.Errorf("expected no channels after reconnection, got %d", count)
+	}
+	expect(esltest.NewEvent("CHANNEL_CREATE", "Unique-ID", "a-leg", "Event-Sequence", "1"), freeswitch.ChannelCreated)
+	expect(esltest.NewEvent("CHANNEL_CREATE", "Unique-ID", "b-leg", "Event-Sequence", "2"), freeswitch.ChannelCreated)
+	if count := channels.Len(); count != 2 {
+		t.Errorf("expected 2 channels, got %d", count)
+	}
+}
+
+func TestClient_OnFiltered(t *testing.T) {
+	fs := esltest.NewServer()
+	defer fs.Close()
+
+	var (
+		c        = newTestClient(fs)
+		answered = make(chan string, 10)
+		done     = make(chan error)
+	)
+	c.Backoff = freeswitch.Backoff{Initial: 10 * time.Millisecond, Jitter: -1}
+	c.OnFiltered("Unique-ID", "a-leg", "CHANNEL_ANSWER", func(e *freeswitch.Event) {
+		answered <- e.Get("Unique-ID")
+	})
+	go func() { done <- c.Supervise() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	for i := 0; i < 2; i++ {
+		if err := c.WaitReady(ctx); err != nil {
+			t.Fatal(err)
+		}
+		expectCommands(t, fs,
+			"auth ClueCon",
+			"events plain BACKGROUND_JOB CHANNEL_ANSWER",
+			"filter Event-Name BACKGROUND_JOB",
+			"filter Unique-ID a-leg",
+		)
+		fs.Emit(esltest.NewEvent("CHANNEL_ANSWER", "Unique-ID", "b-leg"))
+		fs.Emit(esltest.NewEvent("CHANNEL_ANSWER", "Unique-ID", "a-leg"))
+		select {
+		case uuid := <-answered:
+			if uuid != "a-leg" {
+				t.Errorf("unexpected event for %s", uuid)
+			}
+		case <-ctx.Done():
+			t.Fatal("timed out waiting for event")
+		}
+		if i == 0 {
+			fs.Drop()
+		}
+	}
+
+	if err := c.RemoveFilter("Unique-ID", ""); err != nil {
+		t.Fatal(err)
+	}
+	expectCommands(t, fs, "filter delete Unique-ID", "filter delete Event-Name BACKGROUND_JOB")
+
+	c.Shutdown()
+	if err := <-done; err != nil {
+		t.Errorf("expected graceful shutdown, got %s", err)
+	}
+}
+
+func TestClient_OnFiltered_shared(t *testing.T) {
+	fs := esltest.NewServer()
+	defer fs.Close()
+
+	c := newTestClient(fs)
+	go c.Connect()
+	defer c.Shutdown()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := c.WaitReady(ctx); err != nil {
+		t.Fatal(err)
+	}
+	expectCommands(t, fs, "auth ClueCon", "events plain BACKGROUND_JOB")
+
+	nothing := func(*freeswitch.Event) {}
+	cancelAnswer, err := c.OnFiltered("Unique-ID", "a-leg", "CHANNEL_ANSWER", nothing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancelHangup, err := c.OnFiltered("Unique-ID", "a-leg", "CHANNEL_HANGUP", nothing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCommands(t, fs,
+		"events plain CHANNEL_ANSWER",
+		"filter Event-Name BACKGROUND_JOB",
+		"filter Unique-ID a-leg",
+		"events plain CHANNEL_HANGUP",
+	)
+
+	// The filter is kept while another handler, or Filter(), depends on it.
+	c.Filter("Unique-ID", "a-leg")
+	cancelAnswer()
+	expectCommands(t, fs, "nixevent CHANNEL_ANSWER")
+	cancelHangup()
+	expectCommands(t, fs, "nixevent CHANNEL_HANGUP")
+
+	cancelHangup, _ = c.OnFiltered("Unique-ID", "b-leg", "CHANNEL_HANGUP", nothing)
+	expectCommands(t, fs, "events plain CHANNEL_HANGUP", "filter Unique-ID b-leg")
+	cancelHangup()
+	expectCommands(t, fs, "nixevent CHANNEL_HANGUP", "filter delete Unique-ID b-leg")
+
+	fs.HandleCommand("events", func(*esltest.Command) string { return "-ERR no" })
+	if _, err := c.OnFiltered("Unique-ID", "c-leg", "CHANNEL_ANSWER", nothing); err == nil {
+		t.Error("expected subscription error")
+	}
+}
+
+func TestClient_OnFiltered_regexp(t *testing.T) {
+	fs := esltest.NewServer()
+	defer fs.Close()
+
+	c := newTestClient(fs)
+	go c.Connect()
+	defer c.Shutdown()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := c.WaitReady(ctx); err != nil {
+		t.Fatal(err)
+	}
+	expectCommands(t, fs, "auth ClueCon", "events plain BACKGROUND_JOB")
+
+	// An invalid pattern is reported, and nothing is subscribed to or filtered.
+	if _, err := c.OnFiltered("Caller-Caller-ID-Number", "/(/", "CHANNEL_HANGUP", func(*freeswitch.Event) {}); err == nil {
+		t.Error("expected invalid pattern to be reported")
+	}
+
+	answered := make(chan string, 10)
+	if _, err := c.OnFiltered("Caller-Caller-ID-Number", "/^10[0-9]{2}$/", "CHANNEL_ANSWER", func(e *freeswitch.Event) {
+		answered <- e.Get("Caller-Caller-ID-Number")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	expectCommands(t, fs,
+		"events plain CHANNEL_ANSWER",
+		"filter Event-Name BACKGROUND_JOB",
+		"filter Caller-Caller-ID-Number /^10[0-9]{2}$/",
+	)
+	fs.Emit(esltest.NewEvent("CHANNEL_ANSWER", "Caller-Caller-ID-Number", "2000"))
+	fs.Emit(esltest.NewEvent("CHANNEL_ANSWER", "Caller-Caller-ID-Number", "1000"))
+	select {
+	case number := <-answered:
+		if number != "1000" {
+			t.Errorf("unexpected event for %s", number)
+		}
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for event")
+	}
+}
+
+func TestClient_Dispatch(t *testing.T) {
+	fs := esltest.NewServer()
+	defer fs.Close()
+
+	for _, mode := range []freeswitch.DispatchMode{
+		freeswitch.DispatchSerial,
+		freeswitch.DispatchPerChannel,
+		freeswitch.DispatchPool,
+	} {
+		c := newTestClient(fs)
+		c.Dispatch = freeswitch.DispatchOptions{Mode: mode, Workers: 1}
+
+		const count = 100
+		var (
+			sequences = make(chan int, count)
+			previous  int
+		)
+		c.On("CHANNEL_STATE", func(e *freeswitch.Event) { sequences <- e.Sequence() })
+		go c.Connect()
+
+		if _, err := c.Execute("status"); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < count; i++ {
+			fs.Emit(esltest.NewEvent("CHANNEL_STATE", "Unique-ID", "abc"))
+		}
+		for i := 0; i < count; i++ {
+			select {
+			case sequence := <-sequences:
+				if sequence <= previous {
+					t.Fatalf("mode %d: event %d arrived after %d", mode, sequence, previous)
+				}
+				previous = sequence
+			case <-time.After(time.Second):
+				t.Fatalf("mode %d: timed out waiting for event %d", mode, i)
+			}
+		}
+		c.Shutdown()
+	}
+}
+
+func TestClient_Dispatch_overflow(t *testing.T) {
+	fs := esltest.NewServer()
+	defer fs.Close()
+
+	var (
+		c       = newTestClient(fs)
+		release = make(chan struct{})
+		events  = make(chan struct{}, 10)
+	)
+	c.Dispatch = freeswitch.DispatchOptions{
+		Mode:      freeswitch.DispatchPool,
+		Workers:   1,
+		QueueSize: 1,
+		Overflow:  freeswitch.OverflowDropNewest,
+	}
+	c.On("CHANNEL_STATE", func(e *freeswitch.Event) {
+		<-release
+		events <- struct{}{}
+	})
+	go c.Connect()
+	defer c.Shutdown()
+
+	if _, err := c.Execute("status"); err != nil {
+		t.Fatal(err)
+	}
+	const count = 5
+	for i := 0; i < count; i++ {
+		fs.Emit(esltest.NewEvent("CHANNEL_STATE"))
+	}
+
+	// Events are read in order, so by the time this response arrives, every event has been queued or dropped.
+	if _, err := c.Execute("status"); err != nil {
+		t.Fatal(err)
+	}
+	dropped := int(c.DroppedEvents())
+	if dropped == 0 {
+		t.Error("expected events to be dropped")
+	}
+	close(release)
+	for i := 0; i < count-dropped; i++ {
+		select {
+		case <-events:
+		case <-time.After(time.Second):
+			t.Fatalf("expected %d events, got %d", count-dropped, i)
+		}
+	}
+}
+
+func TestClient_OnHandlerPanic(t *testing.T) {
+	fs := esltest.NewServer()
+	defer fs.Close()
+
+	for _, mode := range []freeswitch.DispatchMode{freeswitch.DispatchConcurrent, freeswitch.DispatchSerial} {
+		var (
+			c      = newTestClient(fs)
+			hooked = make(chan interface{}, 1)
+			errs   = make(chan error, 1)
+		)
+		c.Dispatch.Mode = mode
+		c.OnHandlerPanic = func(e *freeswitch.Event, recovered interface{}, stack []byte) {
+			if len(stack) == 0 {
+				t.Error("expected a stack trace")
+			}
+			hooked <- recovered
+		}
+		c.Errors = errs
+		c.On("CHANNEL_STATE", func(e *freeswitch.Event) { panic("oops") })
+		go c.Connect()
+
+		if _, err := c.Execute("status"); err != nil {
+			t.Fatal(err)
+		}
+		fs.Emit(esltest.NewEvent("CHANNEL_STATE"))
+
+		select {
+		case recovered := <-hooked:
+			if recovered != "oops" {
+				t.Errorf("unexpected recovered value %v", recovered)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for hook")
+		}
+		var handlerPanic *freeswitch.HandlerPanic
+		if err := <-errs; !errors.As(err, &handlerPanic) || handlerPanic.Event.Name().Name != "CHANNEL_STATE" {
+			t.Errorf("unexpected error %v", err)
+		}
+
+		// The client is unaffected.
+		if _, err := c.Execute("status"); err != nil {
+			t.Error(err)
+		}
+		c.Shutdown()
+	}
+}
+
+func TestClient_OnAll(t *testing.T) {
+	fs := esltest.NewServer()
+	defer fs.Close()
+
+	var (
+		c       = newTestClient(fs)
+		events  = make(chan string, 10)
+		hangups int32
+	)
+	c.Logger = func(packet string, isOutbound bool) {
+		if !isOutbound && strings.Contains(packet, "CHANNEL_HANGUP") {
+			atomic.AddInt32(&hangups, 1)
+		}
+	}
+	expectEvent := func(expected string) {
+		t.Helper()
+		select {
+		case handler := <-events:
+			if handler != expected {
+				t.Errorf("expected %s handler, got %s", expected, handler)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for %s handler", expected)
+		}
+	}
+	cancelMany := c.OnMany(func(e *freeswitch.Event) { events <- "many" }, "CHANNEL_ANSWER", "CUSTOM sofia::register")
+	go c.Connect()
+	defer c.Shutdown()
+
+	if _, err := c.Execute("status"); err != nil {
+		t.Fatal(err)
+	}
+	expectCommands(t, fs, "auth ClueCon", "events plain BACKGROUND_JOB CHANNEL_ANSWER CUSTOM sofia::register",
+		"api status")
+
+	fs.Emit(esltest.NewCustomEvent("sofia::register"))
+	expectEvent("many")
+
+	cancelGlob := c.OnCustom("sofia::*", func(e *freeswitch.Event) { events <- "glob" })
+	expectCommands(t, fs, "events plain all")
+	fs.Emit(esltest.NewCustomEvent("sofia::expire"))
+	expectEvent("glob")
+
+	cancelAll := c.OnAll(func(e *freeswitch.Event) { events <- "all" })
+	fs.Emit(esltest.NewEvent("CHANNEL_HANGUP"))
+	expectEvent("all")
+
+	cancelAll()
+	cancelGlob()
+	expectCommands(t, fs, "events plain BACKGROUND_JOB CHANNEL_ANSWER CUSTOM sofia::register")
+	cmd, _ := fs.Next()
+	nixed := strings.Fields(cmd)
+	if nixed[0] != "nixevent" || !contains(nixed, "CHANNEL_HANGUP") || contains(nixed, "CHANNEL_ANSWER") ||
+		contains(nixed, "BACKGROUND_JOB") || contains(nixed, "CUSTOM") {
+		t.Errorf("unexpected command %q", cmd)
+	}
+	fs.Emit(esltest.NewEvent("CHANNEL_HANGUP"))
+	fs.Emit(esltest.NewCustomEvent("sofia::expire"))
+	fs.Emit(esltest.NewCustomEvent("sofia::register"))
+	expectEvent("many")
+	if count := atomic.LoadInt32(&hangups); count != 1 {
+		t.Errorf("expected CHANNEL_HANGUP to be sent once, got %d", count)
+	}
+
+	cancelMany()
+	expectCommands(t, fs, "nixevent CHANNEL_ANSWER", "nixevent CUSTOM sofia::register")
+}
+
+func TestClient_Subscribe(t *testing.T) {
+	fs := esltest.NewServer()
+	defer fs.Close()
+
+	c := newTestClient(fs)
+	c.Dispatch.Mode = freeswitch.DispatchSerial
+	events, cancel := c.Subscribe("CHANNEL_ANSWER", "CHANNEL_HANGUP")
+	full, cancelFull := c.SubscribeWith(freeswitch.SubscribeOptions{
+		Buffer:   1,
+		Overflow: freeswitch.OverflowDropOldest,
+	}, "CHANNEL_ANSWER")
+	go c.Connect()
+	defer c.Shutdown()
+
+	if _, err := c.Execute("status"); err != nil {
+		t.Fatal(err)
+	}
+	expectCommands(t, fs, "auth ClueCon", "events plain BACKGROUND_JOB CHANNEL_ANSWER CHANNEL_HANGUP", "api status")
+
+	fs.Emit(esltest.NewEvent("CHANNEL_ANSWER", "Unique-ID", "first"))
+	fs.Emit(esltest.NewEvent("CHANNEL_STATE"))
+	fs.Emit(esltest.NewEvent("CHANNEL_HANGUP"))
+	fs.Emit(esltest.NewEvent("CHANNEL_ANSWER", "Unique-ID", "second"))
+
+	for _, expected := range []string{"CHANNEL_ANSWER", "CHANNEL_HANGUP", "CHANNEL_ANSWER"} {
+		select {
+		case e := <-events:
+			if e.Name().Name != expected {
+				t.Errorf("expected %s, got %s", expected, e.Name())
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for %s", expected)
+		}
+	}
+
+	// Only the newest event is kept when the buffer is full.
+	if _, err := c.Execute("status"); err != nil {
+		t.Fatal(err)
+	}
+	if e := <-full; e.Get("Unique-ID") != "second" {
+		t.Errorf("expected second event, got %s", e.Get("Unique-ID"))
+	}
+	if dropped := c.DroppedEvents(); dropped != 1 {
+		t.Errorf("expected 1 dropped event, got %d", dropped)
+	}
+
+	cancelFull()
+	cancel()
+	if _, ok := <-events; ok {
+		t.Error("expected channel to be closed")
+	}
+	expectCommands(t, fs, "api status", "nixevent CHANNEL_ANSWER", "nixevent CHANNEL_HANGUP")
+}
+
+func TestClient_outOfSync(t *testing.T) {
+	fs := esltest.NewServer()
+	defer fs.Close()
+	fs.RespondAPI("status", "UP")
+	fs.HandleAPI("echo", func(args string) string { return args })
+
+	c := newTestClient(fs)
+	go c.Connect()
+	defer c.Shutdown()
+	if err := c.WaitReady(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	expectCommands(t, fs, "auth ClueCon", "events plain BACKGROUND_JOB")
+
+	// Run a command whose reply will be lost, and wait for it to be sent before running the next.
+	lost := func(run func() error) chan error {
+		fs.LoseReplies(1)
+		failed := make(chan error, 1)
+		go func() { failed <- run() }()
+		if _, ok := fs.Next(); !ok {
+			t.Fatal("expected a command")
+		}
+		return failed
+	}
+	query := func() error {
+		_, err := c.Query("echo", "lost")
+		return err
+	}
+
+	// A later bgapi reply identifies the lost one by its Job-UUID, so only the first command fails.
+	failed := lost(query)
+	if result, err := c.Query("echo", "found"); err != nil || <-result != "found" {
+		t.Errorf("expected bgapi to succeed, got %v", err)
+	}
+	if err := <-failed; err != freeswitch.EOutOfSync {
+		t.Errorf("expected EOutOfSync, got %v", err)
+	}
+	fs.Next()
+
+	// An api/response in place of a command/reply fails both commands, and replies are resynchronised.
+	failed = lost(query)
+	if _, err := c.Execute("status"); err != freeswitch.EOutOfSync {
+		t.Errorf("expected EOutOfSync, got %v", err)
+	}
+	if err := <-failed; err != freeswitch.EOutOfSync {
+		t.Errorf("expected EOutOfSync, got %v", err)
+	}
+	fs.Next()
+	if cmd, _ := fs.Next(); !strings.HasPrefix(cmd, "bgapi status\nJob-UUID: ") {
+		t.Errorf("expected a resync command, got %q", cmd)
+	}
+	for i := 0; i < 3; i++ {
+		if result, err := c.Execute("status"); err != nil || result != "UP" {
+			t.Errorf("unexpected result %q, %v", result, err)
+		}
+	}
+}
+
+func TestClient_outOfSync_resync(t *testing.T) {
+	fs := esltest.NewServer()
+	defer fs.Close()
+	fs.RespondAPI("status", "UP")
+	fs.HandleAPI("echo", func(args string) string { return args })
+
+	// Decides the fate of each resync command's reply.
+	fates := make(chan string, 1)
+	fs.HandleCommand("bgapi", func(cmd *esltest.Command) string {
+		if strings.HasPrefix(cmd.Args, "status") {
+			switch <-fates {
+			case "refuse":
+				return "-ERR refused"
+			case "lose":
+				fs.LoseReplies(1)
+			}
+		}
+		return "+OK"
+	})
+
+	c := newTestClient(fs)
+	done := make(chan error, 1)
+	go func() { done <- c.Connect() }()
+	defer c.Shutdown()
+	if err := c.WaitReady(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	expectCommands(t, fs, "auth ClueCon", "events plain BACKGROUND_JOB")
+
+	// Lose a bgapi reply, so that the next api command's response causes a resync with the given fate.
+	desync := func(fate string) {
+		t.Helper()
+		fates <- fate
+		fs.LoseReplies(1)
+		go c.Query("echo", "lost")
+		fs.Next()
+		if _, err := c.Execute("status"); err != freeswitch.EOutOfSync {
+			t.Fatalf("expected EOutOfSync, got %v", err)
+		}
+		expectCommands(t, fs, "api status")
+		if cmd, _ := fs.Next(); !strings.HasPrefix(cmd, "bgapi status\nJob-UUID: ") {
+			t.Fatalf("expected a resync command, got %q", cmd)
+		}
+	}
+	execute := func() {
+		t.Helper()
+		if result, err := c.Execute("status"); err != nil || result != "UP" {
+			t.Errorf("unexpected result %q, %v", result, err)
+		}
+		fs.Next()
+	}
+
+	// A refusal ends the resync.
+	desync("refuse")
+	execute()
+
+	// A reply to a later bgapi command ends the resync, when the resync command's own reply is lost.
+	desync("lose")
+	if result, err := c.Query("echo", "found"); err != nil || <-result != "found" {
+		t.Errorf("expected bgapi to succeed, got %v", err)
+	}
+	fs.Next()
+	execute()
+
+	// Otherwise, the connection is closed once Timeout passes.
+	desync("lose")
+	if _, err := c.Execute("status"); err != freeswitch.ENotConnected {
+		t.Errorf("expected ENotConnected, got %v", err)
+	}
+	if err := <-done; err != freeswitch.EOutOfSync {
+		t.Errorf("expected EOutOfSync, got %v", err)
+	}
+}
+
+func TestClient_ExecuteBatch(t *testing.T) {
+	fs := esltest.NewServer()
+	defer fs.Close()
+	fs.HandleAPI("echo", func(args string) string { return args })
+	fs.RespondAPI("uuid_kill", "-ERR No such channel!\n")
+
+	c := newTestClient(fs)
+	c.CheckResults = true
+	go c.Connect()
+	defer c.Shutdown()
+
+	results := c.ExecuteBatch(
+		freeswitch.RawCommand{App: "echo", Args: []string{"one"}},
+		freeswitch.UUIDKill{UUID: "abc"},
+		freeswitch.RawCommand{App: "echo", Args: []string{"two"}},
+	)
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(results))
+	}
+	if results[0].Result != "one" || results[0].Err != nil {
+		t.Errorf("unexpected first result %+v", results[0])
+	}
+	var apiErr *freeswitch.APIError
+	if !errors.As(results[1].Err, &apiErr) || apiErr.Cause != "No such channel!" {
+		t.Errorf("expected an APIError, got %v", results[1].Err)
+	}
+	if results[2].Result != "two" || results[2].Err != nil {
+		t.Errorf("unexpected third result %+v", results[2])
+	}
+	expectCommands(t, fs, "auth ClueCon", "events plain BACKGROUND_JOB", "api echo one", "api uuid_kill abc",
+		"api echo two")
+
+	jobs := c.QueryBatch(
+		freeswitch.RawCommand{App: "echo", Args: []string{"three"}},
+		freeswitch.UUIDSetVar{UUID: "abc", Name: "foo", Value: "bar baz"},
+	)
+	if jobs[0].Err != nil || jobs[1].Err != nil {
+		t.Fatalf("unexpected errors %v, %v", jobs[0].Err, jobs[1].Err)
+	}
+	for i, expected := range []string{"three", "-ERR uuid_setvar Command not found!\n"} {
+		select {
+		case result := <-jobs[i].Result:
+			if result != expected {
+				t.Errorf("expected %q, got %q", expected, result)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for background job")
+		}
+	}
+	for _, prefix := range []string{"bgapi echo three\nJob-UUID: ", "bgapi uuid_setvar abc foo bar baz\nJob-UUID: "} {
+		if cmd, _ := fs.Next(); !strings.HasPrefix(cmd, prefix) {
+			t.Errorf("expected %q, got %q", prefix, cmd)
+		}
+	}
+}
+
+func TestClient_APILimits(t *testing.T) {
+	fs := esltest.NewServer()
+	defer fs.Close()
+	release := make(chan struct{})
+	fs.HandleAPI("slow", func(string) string {
+		<-release
+		return "done"
+	})
+
+	c := newTestClient(fs)
+	c.APILimits.MaxInFlight = 1
+	c.BgAPILimits.MaxInFlight = 1
+	go c.Connect()
+	defer c.Shutdown()
+
+	// The second command waits for the first to complete.
+	results := make(chan string, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			result, _ := c.Execute("slow")
+			results <- result
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	if stats := c.APILimitStats(); stats.InFlight != 1 || stats.Waiting != 1 {
+		t.Errorf("expected one command in flight and one waiting, got %+v", stats)
+	}
+	release <- struct{}{}
+	<-results
+	release <- struct{}{}
+	<-results
+	if stats := c.APILimitStats(); stats.Admitted != 2 || stats.Delayed != 1 || stats.InFlight != 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	// A background job holds its place until it completes, so the next waits until Timeout.
+	job, err := c.Query("slow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Query("slow"); err != freeswitch.ETimeout {
+		t.Errorf("expected ETimeout, got %v", err)
+	}
+	release <- struct{}{}
+	<-job
+	if _, err := c.Query("echo"); err != nil {
+		t.Errorf("expected job to be accepted, got %v", err)
+	}
+	if stats := c.BgAPILimitStats(); stats.Admitted != 2 || stats.Rejected != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	// A refused job frees its place immediately.
+	fs.HandleCommand("bgapi", func(cmd *esltest.Command) string {
+		if strings.HasPrefix(cmd.Args, "refused") {
+			return "-ERR no thanks"
+		}
+		return "+OK"
+	})
+	for i := 0; i < 2; i++ {
+		var apiErr *freeswitch.APIError
+		if _, err := c.Query("refused"); !errors.As(err, &apiErr) || apiErr.Cause != "no thanks" {
+			t.Errorf("expected refusal, got %v", err)
+		}
+	}
+	if stats := c.BgAPILimitStats(); stats.InFlight != 0 || stats.Rejected != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	// A batch larger than MaxInFlight is sent in parts.
+	fs.RespondAPI("fast", "ok")
+	for i, r := range c.ExecuteBatch(freeswitch.RawCommand{App: "fast"}, freeswitch.RawCommand{App: "fast"},
+		freeswitch.RawCommand{App: "fast"}) {
+		if r.Result != "ok" || r.Err != nil {
+			t.Errorf("unexpected result %d: %+v", i, r)
+		}
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
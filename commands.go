@@ -0,0 +1,269 @@
+package freeswitch
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// APICommand is a typed API command, which can be run with Client.Run().
+type APICommand interface {
+	// Command returns the name and arguments of the command, as they would be passed to Client.Execute().
+	Command() (app string, args []string)
+}
+
+// Implemented by commands with fields that can't always be encoded.
+type validator interface {
+	validate() error
+}
+
+// Returns an error if the command can't be encoded, in which case it mustn't be sent.
+func validate(cmd APICommand) error {
+	if v, ok := cmd.(validator); ok {
+		return v.validate()
+	}
+	return nil
+}
+
+// Leg is a single endpoint to dial, with variables that apply only to that leg.
+type Leg struct {
+	// The endpoint to dial, e.g. "user/1000" or "sofia/gateway/carrier/15551234567".
+	Endpoint string
+
+	// Variables to set on this leg only.
+	Variables Variables
+}
+
+// Originate makes a new call. See https://freeswitch.org/confluence/display/FREESWITCH/mod_commands#originate.
+type Originate struct {
+	// Variables to set on every leg.
+	Variables Variables
+
+	// The legs to dial simultaneously. At least one is required.
+	Legs []Leg
+
+	// The extension to which answered calls are sent, or an application to run on them, e.g. "&park()".
+	Destination string
+
+	// The dialplan and context in which to find Destination (defaults "XML" and "default").
+	Dialplan string
+	Context  string
+
+	// Caller ID to present to the called party.
+	CallerIDName   string
+	CallerIDNumber string
+
+	// How long to wait for an answer (FreeSWITCH's default is 60 seconds). Rounded up to whole seconds.
+	Timeout time.Duration
+}
+
+// UUIDKill hangs up a channel.
+type UUIDKill struct {
+	UUID string
+
+	// Optional hangup cause, e.g. "USER_BUSY" (FreeSWITCH's default is "NORMAL_CLEARING").
+	Cause string
+}
+
+// UUIDSetVar sets a channel variable. An empty Value unsets the variable.
+type UUIDSetVar struct {
+	UUID  string
+	Name  string
+	Value string
+}
+
+// UUIDTransfer transfers a channel to a new destination.
+type UUIDTransfer struct {
+	UUID string
+
+	// Transfer the other (bridged) leg instead of the given channel.
+	BLeg bool
+
+	// Transfer both legs.
+	Both bool
+
+	// The destination extension, or inline dialplan applications, e.g. "answer,park".
+	Destination string
+
+	// The dialplan and context in which to find Destination (FreeSWITCH's defaults are "XML" and "default").
+	Dialplan string
+	Context  string
+}
+
+// UUIDBridge bridges two existing channels.
+type UUIDBridge struct {
+	UUID      string
+	OtherUUID string
+}
+
+// Conference runs a conference API command, e.g. Conference{"3001", "kick", []string{"12"}}.
+type Conference struct {
+	Name   string
+	Action string
+	Args   []string
+}
+
+// RawCommand is any API command, with its arguments given as they would be passed to Client.Execute(). Use it where
+// a command has no type of its own, e.g. in a batch. See Client.ExecuteBatch().
+type RawCommand struct {
+	App  string
+	Args []string
+}
+
+// SofiaStatus reports the status of the SIP stack, a SIP profile, or a gateway. Set at most one of Profile and
+// Gateway.
+type SofiaStatus struct {
+	Profile string
+	Gateway string
+}
+
+// Run executes a typed command. Results beginning with "+OK" have that prefix and surrounding whitespace removed.
+// Results beginning with "-ERR" or "-USAGE" are returned as an *APIError. See ParseResult(). A command that can't be
+// encoded, such as an Originate whose variables contain unbalanced delimiters, fails without being sent.
+func (c *Client) Run(cmd APICommand) (string, error) {
+	return c.RunContext(context.Background(), cmd)
+}
+
+// RunContext is the same as Run(), but gives up when the given context is done. See ExecuteContext().
+func (c *Client) RunContext(ctx context.Context, cmd APICommand) (string, error) {
+	if err := validate(cmd); err != nil {
+		return "", err
+	}
+	app, args := cmd.Command()
+	result, err := c.ExecuteContext(ctx, app, args...)
+	if err != nil {
+		return "", err
+	}
+	return parseResult(app, result)
+}
+
+// Command implements APICommand.
+func (o Originate) Command() (string, []string) {
+	legs := make([]string, len(o.Legs))
+	for i, leg := range o.Legs {
+		legs[i] = leg.Variables.encode('[', ']') + leg.Endpoint
+	}
+	args := []string{
+		// Not quoted as a whole, since variable values are quoted as needed. See escapeVariable().
+		o.Variables.encode('{', '}') + strings.Join(legs, ","),
+		quoteArg(o.Destination),
+	}
+	var (
+		optional = []string{o.Dialplan, o.Context, o.CallerIDName, o.CallerIDNumber, ""}
+		defaults = []string{"XML", "default", "undef", "undef", ""}
+		count    int
+	)
+	if o.Timeout > 0 {
+		// Zero would mean no timeout at all
+		optional[4] = strconv.Itoa(int((o.Timeout + time.Second - 1) / time.Second))
+	}
+
+	// Positional arguments can't be skipped, so fill gaps with defaults, and omit trailing arguments that aren't set.
+	for i, arg := range optional {
+		if arg != "" {
+			count = i + 1
+		}
+	}
+	for i := 0; i < count; i++ {
+		args = append(args, quoteArg(orDefault(optional[i], defaults[i])))
+	}
+	return "originate", args
+}
+
+// Variables can't be encoded if they contain the delimiters around them.
+func (o Originate) validate() error {
+	if err := o.Variables.check('{', '}'); err != nil {
+		return err
+	}
+	for _, leg := range o.Legs {
+		if err := leg.Variables.check('[', ']'); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Command implements APICommand.
+func (k UUIDKill) Command() (string, []string) {
+	args := []string{k.UUID}
+	if k.Cause != "" {
+		args = append(args, k.Cause)
+	}
+	return "uuid_kill", args
+}
+
+// Command implements APICommand.
+func (s UUIDSetVar) Command() (string, []string) {
+	args := []string{s.UUID, s.Name}
+	if s.Value != "" {
+		args = append(args, s.Value)
+	}
+	return "uuid_setvar", args
+}
+
+// Command implements APICommand.
+func (t UUIDTransfer) Command() (string, []string) {
+	args := []string{t.UUID}
+	if t.Both {
+		args = append(args, "-both")
+	} else if t.BLeg {
+		args = append(args, "-bleg")
+	}
+	args = append(args, quoteArg(t.Destination))
+	if t.Dialplan != "" || t.Context != "" {
+		args = append(args, orDefault(t.Dialplan, "XML"))
+	}
+	if t.Context != "" {
+		args = append(args, quoteArg(t.Context))
+	}
+	return "uuid_transfer", args
+}
+
+// Command implements APICommand.
+func (b UUIDBridge) Command() (string, []string) {
+	return "uuid_bridge", []string{b.UUID, b.OtherUUID}
+}
+
+// Command implements APICommand.
+func (c Conference) Command() (string, []string) {
+	args := []string{quoteArg(c.Name), c.Action}
+	for _, arg := range c.Args {
+		args = append(args, quoteArg(arg))
+	}
+	return "conference", args
+}
+
+// Command implements APICommand.
+func (r RawCommand) Command() (string, []string) {
+	return r.App, r.Args
+}
+
+// Command implements APICommand.
+func (s SofiaStatus) Command() (string, []string) {
+	switch {
+	case s.Profile != "":
+		return "sofia", []string{"status", "profile", s.Profile}
+	case s.Gateway != "":
+		return "sofia", []string{"status", "gateway", s.Gateway}
+	}
+	return "sofia", []string{"status"}
+}
+
+// Quote an API command argument if it contains spaces or quotes, escaping any quotes and backslashes within it.
+func quoteArg(arg string) string {
+	if strings.ContainsAny(arg, " '") {
+		return "'" + argEscaper.Replace(arg) + "'"
+	}
+	return arg
+}
+
+// Escapes characters that FreeSWITCH would otherwise take as the end of a quoted argument, or an escape.
+var argEscaper = strings.NewReplacer(`\`, `\\`, "'", `\'`)
+
+func orDefault(value, defaultValue string) string {
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
@@ -0,0 +1,100 @@
+package freeswitch
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestOriginate_Command(t *testing.T) {
+	app, args := Originate{
+		Variables: Variables{
+			"origination_caller_id_name": "Jo Bloggs",
+			"ignore_early_media":         "true",
+		},
+		Legs: []Leg{
+			{Endpoint: "user/1000", Variables: Variables{"sip_h_X-Tags": "a,b"}},
+			{Endpoint: "user/1001"},
+		},
+		Destination:  "&park()",
+		CallerIDName: "Jo Bloggs",
+		Timeout:      30 * time.Second,
+	}.Command()
+	Equals(t, "originate", app)
+	Equals(t, []string{
+		`{ignore_early_media=true,origination_caller_id_name='Jo Bloggs'}[sip_h_X-Tags=a\,b]user/1000,user/1001`,
+		"&park()",
+		"XML",
+		"default",
+		"'Jo Bloggs'",
+		"undef",
+		"30",
+	}, args)
+
+	_, args = Originate{Legs: []Leg{{Endpoint: "user/1000"}}, Destination: "1001"}.Command()
+	Equals(t, []string{"user/1000", "1001"}, args)
+
+	variables := Variables{}
+	variables.SetArray("sip_h_X-Tags", "red", "green")
+	_, args = Originate{Variables: variables, Legs: []Leg{{Endpoint: "user/1000"}}, Destination: "1001"}.Command()
+	Equals(t, []string{"{sip_h_X-Tags=ARRAY::red|:green}user/1000", "1001"}, args)
+
+	// Arguments with quotes are quoted too, and a timeout under a second isn't sent as none at all.
+	_, args = Originate{
+		Legs:         []Leg{{Endpoint: "user/1000"}},
+		Destination:  "1001",
+		CallerIDName: `Jo's \ Bar`,
+		Timeout:      500 * time.Millisecond,
+	}.Command()
+	Equals(t, []string{"user/1000", "1001", "XML", "default", `'Jo\'s \\ Bar'`, "undef", "1"}, args)
+	_, args = Originate{
+		Legs:        []Leg{{Endpoint: "user/1000"}},
+		Destination: "1001",
+		Timeout:     1500 * time.Millisecond,
+	}.Command()
+	Equals(t, "2", args[len(args)-1])
+}
+
+func TestOriginate_validate(t *testing.T) {
+	valid := Originate{
+		Variables: Variables{"origination_caller_id_number": "${caller_id_number}"},
+		Legs:      []Leg{{Endpoint: "user/1000", Variables: Variables{"sip_h_X-Tags": "[a]"}}},
+	}
+	Equals(t, nil, valid.validate())
+
+	for _, o := range []Originate{
+		{Variables: Variables{"a": "b}"}},
+		{Variables: Variables{"a": "{b"}},
+		{Legs: []Leg{{Endpoint: "user/1000", Variables: Variables{"a": "b]"}}}},
+	} {
+		Assert(t, o.validate() != nil, "expected variables to be rejected")
+		_, err := (&Client{}).RunContext(context.Background(), o)
+		Assert(t, err != nil, "expected command not to be sent")
+	}
+}
+
+func TestUUIDTransfer_Command(t *testing.T) {
+	app, args := UUIDTransfer{UUID: "abc", BLeg: true, Destination: "1000", Context: "public"}.Command()
+	Equals(t, "uuid_transfer", app)
+	Equals(t, []string{"abc", "-bleg", "1000", "XML", "public"}, args)
+
+	_, args = UUIDTransfer{UUID: "abc", Destination: "Jo's line"}.Command()
+	Equals(t, []string{"abc", `'Jo\'s line'`}, args)
+}
+
+func TestParseResult(t *testing.T) {
+	result, err := ParseResult("+OK 1a2b3c\n")
+	Equals(t, "1a2b3c", result)
+	Equals(t, nil, err)
+
+	_, err = parseResult("originate", "-ERR NO_ROUTE_DESTINATION\n")
+	Equals(t, &APIError{Command: "originate", Cause: "NO_ROUTE_DESTINATION"}, err)
+	Equals(t, "originate: NO_ROUTE_DESTINATION", err.Error())
+
+	_, err = ParseResult("-USAGE: <uuid> [cause]\n")
+	Equals(t, &APIError{Cause: "<uuid> [cause]", Usage: true}, err)
+
+	result, err = ParseResult("UP 0 years\n")
+	Equals(t, "UP 0 years\n", result)
+	Equals(t, nil, err)
+}
@@ -0,0 +1,155 @@
+package freeswitch
+
+import (
+	"context"
+	"time"
+)
+
+// FreeSWITCH replies to commands in the order it receives them, without saying which command a reply is for, so
+// replies are matched to commands by their order. If a reply is lost, or one arrives that wasn't asked for, every
+// following command would receive the reply meant for another. To prevent that, each reply is checked against the
+// command at the head of the queue:
+//
+//   - "api" commands expect an api/response, and all other commands a command/reply.
+//   - "bgapi" commands are given a Job-UUID, which FreeSWITCH includes in its reply.
+//
+// When a reply doesn't match, the commands awaiting replies fail with EOutOfSync, unless the reply carries the
+// Job-UUID of a later bgapi command, in which case only the commands before it fail. Otherwise, the client sends a
+// "bgapi" of its own, and discards replies until that command's reply arrives, after which replies match commands
+// again. Replies to later bgapi commands are still delivered while resynchronising, and end it, as does a refusal,
+// since either means the client's own reply won't arrive. If resynchronising takes longer than the client's Timeout,
+// the connection is closed with EOutOfSync.
+//
+// Apart from those to bgapi commands, replies of the same kind can't be told apart, so a lost reply is detected only
+// when a reply of another kind, or with a Job-UUID, arrives in its place. Query(), and Execute() with
+// PreventSocketBlocking, use bgapi, so they are always checked.
+
+// The API command run to resynchronise replies with commands. Its result is ignored.
+const resyncCommand = "status"
+
+// A command awaiting a response.
+type command struct {
+	command  []string
+	response chan packet
+	expect   packetType // the type of packet FreeSWITCH will respond with
+	jobID    string     // the Job-UUID given to a bgapi command, which FreeSWITCH includes in its reply
+	err      error      // why a nil response was sent, if not disconnection
+	release  func()     // if set, called once the response has been sent
+	resync   bool       // true if the client sent the command to resynchronise replies with commands
+}
+
+func newCommand(args []string) *command {
+	cmd := &command{
+		command: args,
+		expect:  ptCommandReply,
+
+		// Buffered so that the response to an abandoned command doesn't block the connection. The command stays
+		// in the queue until its response arrives, keeping later responses matched to their commands.
+		response: make(chan packet, 1),
+	}
+	if len(args) > 0 && args[0] == "api" {
+		cmd.expect = ptResult
+	}
+	return cmd
+}
+
+// Returns true if the given packet could be the response to the command.
+func (cmd *command) matches(p packet) bool {
+	if p.packetType() != cmd.expect {
+		return false
+	}
+	if r, ok := p.(*reply); ok {
+		// A bgapi command is refused without a Job-UUID, but accepted only with its own.
+		if jobID := r.jobID(); jobID != "" || (cmd.jobID != "" && r.ok()) {
+			return jobID == cmd.jobID
+		}
+	}
+	return true
+}
+
+// Wait for the command's response, or for the context to be done.
+func (cmd *command) wait(ctx context.Context) (packet, error) {
+	select {
+	case result := <-cmd.response:
+		if result == nil {
+			if cmd.err != nil {
+				return nil, cmd.err
+			}
+			return nil, ENotConnected
+		}
+		return result, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Send the command's response, which is nil if the command failed.
+func (cmd *command) respond(p packet) {
+	cmd.response <- p
+	if cmd.release != nil {
+		cmd.release()
+	}
+}
+
+// Fail the command with the given error.
+func (cmd *command) fail(err error) {
+	cmd.err = err
+	cmd.respond(nil)
+}
+
+// Deliver a response to the command at the head of the queue, and return the remaining queue. If the response
+// doesn't match that command, affected commands fail, and replies are resynchronised.
+func (c *Client) correlate(queue []*command, p packet) (remaining []*command, err error) {
+	if len(queue) == 0 {
+		return queue, nil // Unsolicited, and harmless
+	}
+	head := queue[0]
+	r, isReply := p.(*reply)
+	if head.resync {
+		switch {
+		case isReply && r.jobID() == head.jobID:
+			return queue[1:], nil
+		case isReply && r.jobID() == "" && !r.ok():
+			// The resync command was refused, so the replies that follow are those of later commands
+			return queue[1:], nil
+		case !isReply || r.jobID() == "":
+			// Replies before the resync command's belong to commands that have already failed
+			return queue, nil
+		}
+	} else if head.matches(p) {
+		head.respond(p)
+		return queue[1:], nil
+	}
+	if isReply && r.jobID() != "" {
+		for i, cmd := range queue {
+			if cmd.jobID == r.jobID() {
+				for _, skipped := range queue[:i] {
+					skipped.fail(EOutOfSync)
+				}
+				cmd.respond(p)
+				return queue[i+1:], nil
+			}
+		}
+	}
+	if head.resync {
+		return queue, nil // A late reply to a command that has already failed
+	}
+	for _, cmd := range queue {
+		cmd.fail(EOutOfSync)
+	}
+	resync := newJobCommand(resyncCommand, nil)
+	resync.resync = true
+	return []*command{resync}, c.write(resync.command...)
+}
+
+// Returns a channel through which the resynchronisation at the head of the queue times out, which is the current
+// one if it has already begun, or nil if replies are not being resynchronised.
+func (c *Client) resyncTimeout(queue []*command, current <-chan time.Time) <-chan time.Time {
+	switch {
+	case len(queue) == 0 || !queue[0].resync:
+		return nil
+	case current == nil:
+		return time.After(c.Timeout)
+	}
+	return current
+}
@@ -0,0 +1,167 @@
+package freeswitch
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The struct tag used by Event.Decode().
+const decodeTag = "fs"
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// Decode copies the event's headers into the struct pointed to by into, according to the fields' "fs" tags, e.g.:
+//
+//	type Registration struct {
+//		User    string    `fs:"from-user"`
+//		Expires int       `fs:"expires"`
+//		Time    time.Time `fs:"Event-Date-Timestamp"`
+//	}
+//
+// Supported field types are strings, integers, floats, bools ("true", "yes", "on" etc.), and time.Time, which accepts
+// FreeSWITCH's microsecond timestamps, as well as its "Event-Date-Local" and "Event-Date-GMT" formats. Timestamps of
+// zero, and missing headers, leave fields unchanged.
+//
+// The tag "_body" refers to the event's body. A tag ending in "*" fills a map[string]string with every header
+// beginning with what precedes the "*" (ignoring case), keyed by the remainder of the header's name, e.g.
+// `fs:"variable_*"`.
+//
+// A struct field's tag is a prefix for the tags of its own fields, e.g. a field tagged `fs:"Caller-"` with a field
+// tagged `fs:"Unique-ID"` is decoded from the "Caller-Unique-ID" header. Untagged embedded structs are decoded with
+// no prefix. Other untagged fields, and fields tagged "-", are ignored.
+func (e *Event) Decode(into interface{}) error {
+	v := reflect.ValueOf(into)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return errors.New("freeswitch: Decode requires a pointer to a struct")
+	}
+	e.read()
+	return e.decodeStruct(v.Elem(), "")
+}
+
+func (e *Event) decodeStruct(v reflect.Value, prefix string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag, tagged := field.Tag.Lookup(decodeTag)
+		if tag == "-" || field.PkgPath != "" {
+			continue
+		}
+		value := v.Field(i)
+		if field.Type.Kind() == reflect.Struct && field.Type != timeType {
+			if tagged || field.Anonymous {
+				if err := e.decodeStruct(value, prefix+tag); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if !tagged {
+			continue
+		}
+		name := prefix + tag
+		if strings.HasSuffix(name, "*") {
+			if field.Type.Kind() == reflect.Map && field.Type.Key().Kind() == reflect.String &&
+				field.Type.Elem().Kind() == reflect.String {
+				e.decodeMap(value, strings.TrimSuffix(name, "*"))
+				continue
+			}
+			return fmt.Errorf("freeswitch: can't decode %s into %s", name, field.Type)
+		}
+		var str string
+		if name == jsonBodyKey {
+			str = e.body
+		} else {
+			str = e.Get(name)
+		}
+		if str == "" {
+			continue
+		}
+		if err := decodeValue(value, str); err != nil {
+			return fmt.Errorf("freeswitch: can't decode %s %q into %s: %w", name, str, field.Type, err)
+		}
+	}
+	return nil
+}
+
+func (e *Event) decodeMap(v reflect.Value, prefix string) {
+	for _, h := range e.headers.list {
+		if name, ok := trimPrefixFold(h.name, prefix); ok {
+			if v.IsNil() {
+				v.Set(reflect.MakeMap(v.Type()))
+			}
+			v.SetMapIndex(reflect.ValueOf(name).Convert(v.Type().Key()),
+				reflect.ValueOf(h.value).Convert(v.Type().Elem()))
+		}
+	}
+}
+
+func decodeValue(v reflect.Value, str string) error {
+	if v.Type() == timeType {
+		t, err := parseTime(str)
+		if err == nil && !t.IsZero() {
+			v.Set(reflect.ValueOf(t))
+		}
+		return err
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(str)
+	case reflect.Bool:
+		b, err := parseBool(str)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(str, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(str, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(str, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n)
+	default:
+		return errors.New("unsupported type")
+	}
+	return nil
+}
+
+// Parse a boolean in any of the forms FreeSWITCH uses, e.g. "true", "yes", "on" or "1".
+func parseBool(str string) (bool, error) {
+	switch strings.ToLower(str) {
+	case "true", "yes", "on", "1":
+		return true, nil
+	case "false", "no", "off", "0":
+		return false, nil
+	}
+	return false, errors.New("invalid boolean")
+}
+
+// Parse a timestamp in microseconds since the epoch, or in the format of the Event-Date-Local or Event-Date-GMT
+// headers. A zero timestamp, which FreeSWITCH uses for times that haven't happened, gives a zero time.
+func parseTime(str string) (time.Time, error) {
+	if micros, err := strconv.ParseInt(str, 10, 64); err == nil {
+		if micros == 0 {
+			return time.Time{}, nil
+		}
+		return time.Unix(micros/1e6, micros%1e6*1e3), nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02 15:04:05", str, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC1123, str)
+}
@@ -0,0 +1,276 @@
+package freeswitch
+
+import (
+	"hash/fnv"
+	"runtime"
+	"runtime/debug"
+	"sync/atomic"
+)
+
+const (
+	defaultDispatchQueueSize = 1024
+
+	// How many events the serial dispatcher handles between sweeps for queues of removed handlers.
+	dispatchSweepInterval = 1024
+)
+
+// DispatchMode determines how events are passed to their handlers. See DispatchOptions.
+type DispatchMode int
+
+const (
+	// Each handler is called in its own goroutine for each event. Handlers may see events out of order, and an event
+	// storm can start any number of goroutines. This is the default.
+	DispatchConcurrent DispatchMode = iota
+
+	// Each handler receives events one at a time, in the order they arrived, from its own queue. Different handlers
+	// run concurrently. A handler registered for several events with OnMany() has a single queue.
+	DispatchSerial
+
+	// Events are queued by their Unique-ID header, so that events for the same channel are handled one at a time, in
+	// the order they arrived. Events for different channels may be handled concurrently. Events without a Unique-ID
+	// share a queue.
+	DispatchPerChannel
+
+	// Events are queued for a fixed number of workers. Events are handled in the order they arrived, but with more
+	// than one worker, handling may overlap.
+	DispatchPool
+)
+
+// OverflowPolicy determines what happens when an event arrives for a full dispatch queue.
+type OverflowPolicy int
+
+const (
+	// Wait for the queue to make room. This stalls the connection, including responses to commands, until handlers
+	// catch up. This is the default.
+	OverflowBlock OverflowPolicy = iota
+
+	// Discard the oldest queued event to make room for the new one.
+	OverflowDropOldest
+
+	// Discard the new event.
+	OverflowDropNewest
+)
+
+// DispatchOptions configure how a client passes events to its handlers. The zero value gives the original behaviour
+// of a goroutine per handler per event.
+type DispatchOptions struct {
+	// How events are passed to handlers (default DispatchConcurrent).
+	Mode DispatchMode
+
+	// The number of workers for DispatchPool, or the number of queues for DispatchPerChannel (default GOMAXPROCS).
+	Workers int
+
+	// The capacity of each queue (default 1024). Not used by DispatchConcurrent.
+	QueueSize int
+
+	// What to do when a queue is full (default OverflowBlock). Not used by DispatchConcurrent.
+	Overflow OverflowPolicy
+}
+
+// DroppedEvents returns the number of events discarded because dispatch queues or subscription channels were full.
+// See DispatchOptions and SubscribeOptions.
+func (c *Client) DroppedEvents() uint64 {
+	return c.dropped.Load()
+}
+
+// Passes events from a single connection to their handlers. Only the connection's loop calls its methods.
+type dispatcher struct {
+	client  *Client
+	options DispatchOptions
+	queues  []*eventQueue                 // DispatchPool (one shared queue) or DispatchPerChannel
+	lanes   map[*handlerGroup]*eventQueue // DispatchSerial, and ordered groups in other modes
+	count   int                           // events dispatched since the last sweep of lanes
+}
+
+// An event, and the handlers to which it should be passed.
+type dispatchItem struct {
+	event    *Event
+	handlers []*registeredHandler
+}
+
+// A bounded queue of events, and the goroutines working through it.
+type eventQueue struct {
+	client   *Client
+	items    chan dispatchItem
+	overflow OverflowPolicy
+}
+
+func (c *Client) newDispatcher() *dispatcher {
+	d := &dispatcher{client: c, options: c.Dispatch, lanes: map[*handlerGroup]*eventQueue{}}
+	if d.options.Workers <= 0 {
+		d.options.Workers = runtime.GOMAXPROCS(0)
+	}
+	if d.options.QueueSize <= 0 {
+		d.options.QueueSize = defaultDispatchQueueSize
+	}
+	switch d.options.Mode {
+	case DispatchPool:
+		queue := d.newQueue()
+		for i := 0; i < d.options.Workers; i++ {
+			go queue.work()
+		}
+		d.queues = []*eventQueue{queue}
+	case DispatchPerChannel:
+		for i := 0; i < d.options.Workers; i++ {
+			queue := d.newQueue()
+			go queue.work()
+			d.queues = append(d.queues, queue)
+		}
+	}
+	return d
+}
+
+func (d *dispatcher) newQueue() *eventQueue {
+	return &eventQueue{
+		client:   d.client,
+		items:    make(chan dispatchItem, d.options.QueueSize),
+		overflow: d.options.Overflow,
+	}
+}
+
+// Pass an event to the given handlers.
+func (d *dispatcher) dispatch(e *Event, handlers []*registeredHandler) {
+	if d.options.Mode != DispatchSerial {
+		handlers = d.dispatchOrdered(e, handlers)
+	}
+	if len(handlers) == 0 {
+		return
+	}
+	switch d.options.Mode {
+	case DispatchSerial:
+		for _, h := range handlers {
+			d.lane(h.group).push(dispatchItem{e, []*registeredHandler{h}})
+		}
+		if d.count++; d.count >= dispatchSweepInterval {
+			d.sweep()
+		}
+	case DispatchPool:
+		d.queues[0].push(dispatchItem{e, handlers})
+	case DispatchPerChannel:
+		hash := fnv.New32a()
+		hash.Write([]byte(e.Get("Unique-ID")))
+		d.queues[hash.Sum32()%uint32(len(d.queues))].push(dispatchItem{e, handlers})
+	default:
+		for _, h := range handlers {
+			go d.client.handle(h, e)
+		}
+	}
+}
+
+// Pass an event to those of the given handlers whose groups are ordered, through their lanes, and return the others.
+func (d *dispatcher) dispatchOrdered(e *Event, handlers []*registeredHandler) (others []*registeredHandler) {
+	for i, h := range handlers {
+		if !h.group.ordered {
+			if others != nil {
+				others = append(others, h)
+			}
+			continue
+		}
+		if others == nil {
+			others = append(make([]*registeredHandler, 0, len(handlers)-1), handlers[:i]...)
+		}
+		d.lane(h.group).push(dispatchItem{e, []*registeredHandler{h}})
+	}
+	if others == nil {
+		return handlers
+	}
+	return others
+}
+
+// Returns the lane for the given group, starting one if necessary.
+func (d *dispatcher) lane(group *handlerGroup) *eventQueue {
+	lane := d.lanes[group]
+	if lane == nil {
+		lane = d.newQueue()
+		go lane.work()
+		d.lanes[group] = lane
+	}
+	return lane
+}
+
+// Close the lanes of handlers that have been removed.
+func (d *dispatcher) sweep() {
+	d.count = 0
+	for group, lane := range d.lanes {
+		if atomic.LoadInt32(&group.active) == 0 {
+			close(lane.items)
+			delete(d.lanes, group)
+		}
+	}
+}
+
+// Close all queues. Events already queued are still handled.
+func (d *dispatcher) stop() {
+	for _, queue := range d.queues {
+		close(queue.items)
+	}
+	for _, lane := range d.lanes {
+		close(lane.items)
+	}
+}
+
+// Add an item to the queue, applying the overflow policy if it's full.
+func (q *eventQueue) push(item dispatchItem) {
+	offer(q.client, q.items, item, q.overflow, nil)
+}
+
+// Send an item to a channel, applying the given overflow policy if it's full, and counting any item dropped. With
+// OverflowBlock, gives up if done is closed.
+func offer[T any](c *Client, items chan T, item T, policy OverflowPolicy, done <-chan struct{}) {
+	switch policy {
+	case OverflowDropNewest:
+		select {
+		case items <- item:
+		default:
+			c.dropped.Add(1)
+		}
+	case OverflowDropOldest:
+		for {
+			select {
+			case items <- item:
+				return
+			default:
+				select {
+				case <-items:
+					c.dropped.Add(1)
+				default:
+				}
+			}
+		}
+	default:
+		select {
+		case items <- item:
+		case <-done:
+		}
+	}
+}
+
+// Handle queued items until the queue is closed.
+func (q *eventQueue) work() {
+	for item := range q.items {
+		for _, h := range item.handlers {
+			if !h.isRemoved() {
+				q.client.handle(h, item.event)
+			}
+		}
+	}
+}
+
+// Call a handler, recovering from any panic, and reporting it to OnHandlerPanic and Errors.
+func (c *Client) handle(h *registeredHandler, e *Event) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			stack := debug.Stack()
+			if c.OnHandlerPanic != nil {
+				c.OnHandlerPanic(e, recovered, stack)
+			}
+			if c.Errors != nil {
+				select {
+				case c.Errors <- &HandlerPanic{Event: e, Value: recovered, Stack: stack}:
+				default:
+				}
+			}
+		}
+	}()
+	h.handler(e)
+}
@@ -1,5 +1,10 @@
 package freeswitch
 
+import (
+	"fmt"
+	"strings"
+)
+
 type fsError string
 
 func (e fsError) Error() string {
@@ -13,8 +18,74 @@ const (
 	EBlankHostname        fsError = "hostname cannot be blank"
 	ECommandFailed        fsError = "command failed"
 	EDisconnected         fsError = "host sent disconnection notice"
+	ENotAnswered          fsError = "call ended without being answered"
 	ENotConnected         fsError = "not connected"
+	EOutOfSync            fsError = "response did not match its command"
 	EShutdown             fsError = "shutdown was requested"
 	ETimeout              fsError = "timeout"
 	EUnexpectedResponse   fsError = "unexpected response from FreeSWITCH"
 )
+
+// APIError is the error returned for an API command whose result begins with "-ERR" or "-USAGE". See ParseResult().
+type APIError struct {
+	// The API command that failed, e.g. "originate", if known.
+	Command string
+
+	// The reason given by FreeSWITCH, e.g. "NO_ROUTE_DESTINATION", or the command's usage if Usage is true.
+	Cause string
+
+	// True if FreeSWITCH rejected the command's arguments with a "-USAGE" result.
+	Usage bool
+}
+
+func (e *APIError) Error() string {
+	msg := e.Cause
+	if e.Usage {
+		msg = "usage: " + msg
+	}
+	if e.Command != "" {
+		msg = e.Command + ": " + msg
+	}
+	return msg
+}
+
+// HandlerPanic is a panic recovered from an event handler. It is sent to a client's Errors channel, if it has one.
+type HandlerPanic struct {
+	// The event the handler was given.
+	Event *Event
+
+	// The value passed to panic().
+	Value interface{}
+
+	// The stack trace of the panicking goroutine.
+	Stack []byte
+}
+
+func (p *HandlerPanic) Error() string {
+	return fmt.Sprintf("handler for %s panicked: %v", p.Event.Name(), p.Value)
+}
+
+// ParseResult interprets the result of an API command, e.g. one received through a channel returned by Query().
+// Results beginning with "-ERR" or "-USAGE" are returned as an *APIError. Results beginning with "+OK" have that
+// prefix and surrounding whitespace removed. Other results are returned unchanged.
+func ParseResult(result string) (string, error) {
+	return parseResult("", result)
+}
+
+func parseResult(command, result string) (string, error) {
+	trimmed := strings.TrimSpace(result)
+	switch {
+	case strings.HasPrefix(trimmed, "-ERR"):
+		return "", &APIError{Command: command, Cause: resultText(trimmed, "-ERR")}
+	case strings.HasPrefix(trimmed, "-USAGE"):
+		return "", &APIError{Command: command, Cause: resultText(trimmed, "-USAGE"), Usage: true}
+	case strings.HasPrefix(trimmed, "+OK"):
+		return resultText(trimmed, "+OK"), nil
+	}
+	return result, nil
+}
+
+// Remove a result's prefix, and any separating colon and whitespace.
+func resultText(result, prefix string) string {
+	return strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(result, prefix), ":"))
+}
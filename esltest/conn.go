@@ -0,0 +1,326 @@
+package esltest
+
+import (
+	"bufio"
+	"io"
+	"math/rand"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const disconnectNotice = "Disconnected, goodbye.\nSee you at ClueCon! http://www.cluecon.com/\n"
+
+// A single client connection to a Server.
+type conn struct {
+	server *Server
+	conn   net.Conn
+	write  sync.Mutex
+	lock   sync.Mutex
+
+	// The following are guarded by lock.
+	authenticated bool
+	format        string
+	all           bool
+	rest          bool            // whether events absent from events are sent, once "nixevent" has ended all
+	events        map[string]bool // Event names, or "CUSTOM <subclass>"
+	filters       []filter
+	myEvents      string // Unique-ID given to "myevents"
+}
+
+// An event filter, added with the "filter" command.
+type filter struct {
+	header string
+	value  string
+}
+
+func (c *conn) run() {
+	defer func() {
+		c.conn.Close()
+		exclusive(&c.server.lock, func() { delete(c.server.conns, c) })
+	}()
+	reader := bufio.NewReader(c.conn)
+	c.send("Content-Type: auth/request\n\n")
+	for {
+		lines, body, err := readCommand(reader)
+		if err != nil {
+			return
+		}
+		c.server.record(strings.Join(lines, "\n"))
+		if !c.handle(lines, body) {
+			return
+		}
+	}
+}
+
+// Handle a single command, returning false if the connection should be closed.
+func (c *conn) handle(lines []string, body string) bool {
+	cmd := &Command{Lines: lines[1:]}
+	cmd.Name, cmd.Args = split(lines[0])
+
+	if cmd.Name == "auth" {
+		if cmd.Args != c.server.Password {
+			c.reply("-ERR invalid")
+			c.disconnect()
+			return false
+		}
+		exclusive(&c.lock, func() { c.authenticated = true })
+		c.reply("+OK accepted")
+		return true
+	}
+
+	var authenticated bool
+	exclusive(&c.lock, func() { authenticated = c.authenticated })
+	if !authenticated {
+		c.reply("-ERR command not found")
+		return true
+	}
+
+	switch cmd.Name {
+	case "api":
+		app, args := split(cmd.Args)
+		result := c.server.api(app, args)
+		c.respond("Content-Type: api/response\nContent-Length: " + strconv.Itoa(len(result)) + "\n\n" + result)
+		return true
+	case "bgapi":
+		c.bgapi(cmd)
+		return true
+	}
+
+	if handler := c.server.commandHandler(cmd.Name); handler != nil {
+		c.reply(handler(cmd))
+		return true
+	}
+
+	switch cmd.Name {
+	case "events", "event":
+		format, names := split(cmd.Args)
+		exclusive(&c.lock, func() { c.format = format })
+		c.subscribe(strings.Fields(names), true)
+		c.reply("+OK event listener enabled " + format)
+	case "nixevent":
+		c.subscribe(strings.Fields(cmd.Args), false)
+		c.reply("+OK event listener disabled")
+	case "noevents":
+		exclusive(&c.lock, func() {
+			c.all, c.rest = false, false
+			c.events = map[string]bool{}
+		})
+		c.reply("+OK no longer listening for events")
+	case "myevents":
+		format, uuid := split(cmd.Args)
+		if uuid == "" {
+			format, uuid = "plain", format
+		}
+		exclusive(&c.lock, func() {
+			c.format = format
+			c.all = true
+			c.myEvents = uuid
+		})
+		c.reply("+OK Events Enabled")
+	case "filter":
+		c.filter(cmd.Args)
+	case "divert_events":
+		c.reply("+OK events diverted")
+	case "sendevent":
+		c.sendEvent(cmd, body)
+	case "exit":
+		c.reply("+OK bye")
+		c.disconnect()
+		return false
+	default:
+		c.reply("+OK")
+	}
+	return true
+}
+
+func (c *conn) bgapi(cmd *Command) {
+	if handler := c.server.commandHandler(cmd.Name); handler != nil {
+		if text := handler(cmd); !strings.HasPrefix(text, "+OK") {
+			c.reply(text)
+			return
+		}
+	}
+	jobID := headerValue(cmd.Lines, "Job-UUID")
+	if jobID == "" {
+		jobID = newUUID()
+	}
+	c.respond("Content-Type: command/reply\nReply-Text: +OK Job-UUID: " + jobID + "\nJob-UUID: " + jobID + "\n\n")
+	go func() {
+		app, args := split(cmd.Args)
+		result := c.server.api(app, args)
+		c.server.Emit(NewEvent("BACKGROUND_JOB",
+			"Job-UUID", jobID,
+			"Job-Command", app,
+			"Job-Command-Arg", args,
+		).SetBody(result))
+	}()
+}
+
+func (c *conn) sendEvent(cmd *Command, body string) {
+	uuid := newUUID()
+	e := NewEvent(cmd.Args)
+	for _, line := range cmd.Lines {
+		name, value := splitHeader(line)
+		if !strings.EqualFold(name, "Content-Length") {
+			value, _ = url.PathUnescape(value)
+			e.Set(name, value)
+		}
+	}
+	e.Set("Event-UUID", uuid).SetBody(body)
+	c.reply("+OK " + uuid)
+	c.server.Emit(e)
+}
+
+// Add or remove event subscriptions, in the same format as the "events" and "nixevent" commands. Like FreeSWITCH,
+// removing one event while subscribed to all leaves every other event subscribed.
+func (c *conn) subscribe(names []string, enable bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	custom := false
+	for _, name := range names {
+		switch {
+		case custom:
+			name = "CUSTOM " + name
+		case name == "CUSTOM":
+			custom = true
+			continue
+		case strings.EqualFold(name, "all"):
+			c.all = enable
+			if !enable {
+				c.rest = false
+				c.events = map[string]bool{}
+			}
+			continue
+		}
+		if !enable && c.all && !custom {
+			c.all, c.rest = false, true
+		}
+		c.events[name] = enable
+	}
+}
+
+// Add or delete a filter, in the same format as the "filter" command.
+func (c *conn) filter(args string) {
+	header, value := split(args)
+	if header != "delete" {
+		exclusive(&c.lock, func() { c.filters = append(c.filters, filter{header, value}) })
+		c.reply("+OK filter added. [" + header + "]=[" + value + "]")
+		return
+	}
+	header, value = split(value)
+	exclusive(&c.lock, func() {
+		filters := c.filters[:0]
+		for _, f := range c.filters {
+			if f.header != header || (value != "" && f.value != value) {
+				filters = append(filters, f)
+			}
+		}
+		c.filters = filters
+	})
+	c.reply("+OK filter deleted. [" + header + "]=[" + value + "]")
+}
+
+// Returns true if the connection should receive the given event, and the format in which to send it.
+func (c *conn) subscribed(e *Event) (subscribed bool, format string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	format = c.format
+	if !c.authenticated {
+		return
+	}
+	if c.all {
+		subscribed = true
+	} else if name := e.Get("Event-Name"); name == "CUSTOM" {
+		subscribed = c.events["CUSTOM "+e.Get("Event-Subclass")]
+	} else if enabled, ok := c.events[name]; ok {
+		subscribed = enabled
+	} else {
+		subscribed = c.rest
+	}
+	if c.myEvents != "" && e.Get("Unique-ID") != c.myEvents {
+		subscribed = false
+	}
+	if subscribed && len(c.filters) > 0 {
+		subscribed = false
+		for _, f := range c.filters {
+			if f.matches(e) {
+				subscribed = true
+				break
+			}
+		}
+	}
+	return
+}
+
+// Returns true if the event matches the filter. Values enclosed in slashes are regular expressions.
+func (f filter) matches(e *Event) bool {
+	actual := e.Get(f.header)
+	if len(f.value) > 1 && strings.HasPrefix(f.value, "/") && strings.HasSuffix(f.value, "/") {
+		re, err := regexp.Compile(f.value[1 : len(f.value)-1])
+		return err == nil && re.MatchString(actual)
+	}
+	return actual == f.value
+}
+
+func (c *conn) reply(text string) {
+	c.respond("Content-Type: command/reply\nReply-Text: " + text + "\n\n")
+}
+
+// Send a reply to a command, unless it's to be lost. See Server.LoseReplies().
+func (c *conn) respond(packet string) {
+	if !c.server.loseReply() {
+		c.send(packet)
+	}
+}
+
+func (c *conn) disconnect() {
+	c.send("Content-Type: text/disconnect-notice\nContent-Length: " + strconv.Itoa(len(disconnectNotice)) + "\n\n" +
+		disconnectNotice)
+	c.conn.Close()
+}
+
+func (c *conn) send(packet string) {
+	exclusive(&c.write, func() { io.WriteString(c.conn, packet) })
+}
+
+// Split a string at its first space.
+func split(str string) (first, rest string) {
+	if index := strings.IndexByte(str, ' '); index >= 0 {
+		return str[:index], str[index+1:]
+	}
+	return str, ""
+}
+
+func splitHeader(line string) (name, value string) {
+	if index := strings.IndexByte(line, ':'); index >= 0 {
+		return line[:index], strings.TrimSpace(line[index+1:])
+	}
+	return line, ""
+}
+
+func headerValue(lines []string, name string) string {
+	for _, line := range lines {
+		if n, v := splitHeader(line); strings.EqualFold(n, name) {
+			return v
+		}
+	}
+	return ""
+}
+
+func newUUID() string {
+	const hex = "0123456789abcdef"
+	b := make([]byte, 36)
+	for i := range b {
+		switch i {
+		case 8, 13, 18, 23:
+			b[i] = '-'
+		default:
+			b[i] = hex[rand.Intn(16)]
+		}
+	}
+	return string(b)
+}
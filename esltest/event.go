@@ -0,0 +1,120 @@
+package esltest
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Event is an event that can be emitted by a Server.
+type Event struct {
+	headers []header
+	body    string
+}
+
+type header struct {
+	name  string
+	value string
+}
+
+// NewEvent makes an event with the given name, and optional headers given as alternating names and values, e.g.:
+//
+//	esltest.NewEvent("CHANNEL_ANSWER", "Unique-ID", uuid, "Answer-State", "answered")
+func NewEvent(name string, headers ...string) *Event {
+	e := &Event{headers: []header{{"Event-Name", name}}}
+	for i := 0; i+1 < len(headers); i += 2 {
+		e.headers = append(e.headers, header{headers[i], headers[i+1]})
+	}
+	return e
+}
+
+// NewCustomEvent makes a CUSTOM event with the given subclass. See NewEvent().
+func NewCustomEvent(subclass string, headers ...string) *Event {
+	return NewEvent("CUSTOM", append([]string{"Event-Subclass", subclass}, headers...)...)
+}
+
+// Get returns the value of the first header with the given (case-insensitive) name.
+func (e *Event) Get(name string) string {
+	for _, h := range e.headers {
+		if strings.EqualFold(h.name, name) {
+			return h.value
+		}
+	}
+	return ""
+}
+
+// Set replaces the value of the given header, or adds it if absent.
+func (e *Event) Set(name, value string) *Event {
+	for i, h := range e.headers {
+		if strings.EqualFold(h.name, name) {
+			e.headers[i].value = value
+			return e
+		}
+	}
+	e.headers = append(e.headers, header{name, value})
+	return e
+}
+
+// SetBody sets the event's body.
+func (e *Event) SetBody(body string) *Event {
+	e.body = body
+	return e
+}
+
+// Returns the complete packet for the event, in the given format ("plain", "json" or "xml").
+func (e *Event) packet(format string) string {
+	switch format {
+	case "json":
+		return e.jsonPacket()
+	case "xml":
+		return e.xmlPacket()
+	default:
+		return e.plainPacket()
+	}
+}
+
+func (e *Event) plainPacket() string {
+	var body strings.Builder
+	for _, h := range e.headers {
+		body.WriteString(h.name + ": " + url.PathEscape(h.value) + "\n")
+	}
+	if e.body != "" {
+		body.WriteString("Content-Length: " + strconv.Itoa(len(e.body)) + "\n\n" + e.body)
+	} else {
+		body.WriteString("\n")
+	}
+	return "Content-Length: " + strconv.Itoa(body.Len()) + "\nContent-Type: text/event-plain\n\n" + body.String()
+}
+
+func (e *Event) jsonPacket() string {
+	object := make(map[string]string, len(e.headers)+1)
+	for _, h := range e.headers {
+		object[h.name] = h.value
+	}
+	if e.body != "" {
+		object["_body"] = e.body
+	}
+	body, _ := json.Marshal(object)
+	return "Content-Length: " + strconv.Itoa(len(body)) + "\nContent-Type: text/event-json\n\n" + string(body)
+}
+
+func (e *Event) xmlPacket() string {
+	body := &bytes.Buffer{}
+	body.WriteString("<event>\n  <headers>\n")
+	for _, h := range e.headers {
+		body.WriteString("    <" + h.name + ">")
+		xml.EscapeText(body, []byte(url.PathEscape(h.value)))
+		body.WriteString("</" + h.name + ">\n")
+	}
+	body.WriteString("  </headers>\n")
+	if e.body != "" {
+		body.WriteString("  <body>")
+		xml.EscapeText(body, []byte(e.body))
+		body.WriteString("</body>\n")
+	}
+	body.WriteString("</event>")
+	return "Content-Length: " + strconv.Itoa(body.Len()) + "\nContent-Type: text/event-xml\n\n" + body.String()
+}
@@ -0,0 +1,281 @@
+// Package esltest provides an in-process fake of FreeSWITCH's event socket layer, for testing inbound clients
+// without a running FreeSWITCH.
+//
+// The fake speaks enough of the protocol to authenticate clients, answer "api" and "bgapi" commands from scripted
+// handlers, track "events" subscriptions, accept "sendevent", and deliver injected events. Connections can be
+// dropped, or sent a disconnection notice, at any time.
+package esltest
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultPassword = "ClueCon"
+	defaultTimeout  = 5 * time.Second
+)
+
+// APIHandler produces the body of the response to an "api" or "bgapi" command, given the command's arguments.
+type APIHandler func(args string) string
+
+// CommandHandler produces the Reply-Text of a command/reply to a command, e.g. "+OK" or "-ERR reason".
+type CommandHandler func(cmd *Command) string
+
+// Command is a command received from a client.
+type Command struct {
+	// The first word of the command, e.g. "api" or "events".
+	Name string
+
+	// The remainder of the command's first line.
+	Args string
+
+	// Any lines following the first, e.g. the headers of a "sendevent" command.
+	Lines []string
+}
+
+// Server is a fake FreeSWITCH event socket, listening on a local port. A zero Server is not valid; use NewServer().
+type Server struct {
+	// The password clients must send to authenticate (default "ClueCon").
+	Password string
+
+	listener net.Listener
+	lock     sync.Mutex
+	conns    map[*conn]struct{}
+	apis     map[string]APIHandler
+	commands map[string]CommandHandler
+	received []string
+	cursor   int
+	changed  chan struct{}
+	sequence int
+	closed   bool
+	lose     int // number of replies still to be lost
+}
+
+// NewServer starts a fake FreeSWITCH listening on a random local port. Call Close() when done.
+func NewServer() *Server {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic("esltest: failed to listen: " + err.Error())
+	}
+	s := &Server{
+		Password: defaultPassword,
+
+		listener: listener,
+		conns:    map[*conn]struct{}{},
+		apis:     map[string]APIHandler{},
+		commands: map[string]CommandHandler{},
+		changed:  make(chan struct{}),
+	}
+	go s.accept()
+	return s
+}
+
+// Host returns the IP address on which the server is listening.
+func (s *Server) Host() string {
+	return s.listener.Addr().(*net.TCPAddr).IP.String()
+}
+
+// Port returns the port on which the server is listening.
+func (s *Server) Port() uint16 {
+	return uint16(s.listener.Addr().(*net.TCPAddr).Port)
+}
+
+// Close stops the server and drops all of its connections.
+func (s *Server) Close() {
+	exclusive(&s.lock, func() { s.closed = true })
+	s.listener.Close()
+	s.Drop()
+}
+
+// HandleAPI scripts responses to the given API command, whether run with "api" or "bgapi". Commands with no handler
+// receive a "-ERR <app> Command not found!" response.
+func (s *Server) HandleAPI(app string, handler APIHandler) {
+	exclusive(&s.lock, func() { s.apis[app] = handler })
+}
+
+// RespondAPI scripts a fixed response to the given API command. See HandleAPI().
+func (s *Server) RespondAPI(app string, body string) {
+	s.HandleAPI(app, func(string) string { return body })
+}
+
+// HandleCommand scripts the reply to the given command, e.g. "events" or "sendevent", overriding the server's
+// default behaviour. The "auth" and "api" commands cannot be overridden. A "bgapi" handler can refuse jobs, by
+// replying with anything but "+OK"; jobs it accepts are run as usual.
+func (s *Server) HandleCommand(name string, handler CommandHandler) {
+	exclusive(&s.lock, func() { s.commands[name] = handler })
+}
+
+// Commands returns every command received so far, from all connections, in the order received. Multi-line
+// commands have their lines joined with "\n".
+func (s *Server) Commands() []string {
+	var commands []string
+	exclusive(&s.lock, func() { commands = append(commands, s.received...) })
+	return commands
+}
+
+// Next returns the oldest received command not already returned by Next(), waiting up to 5 seconds for one to
+// arrive. It returns false if none arrives in time.
+func (s *Server) Next() (cmd string, ok bool) {
+	timeout := time.After(defaultTimeout)
+	for {
+		var changed chan struct{}
+		exclusive(&s.lock, func() {
+			if ok = s.cursor < len(s.received); ok {
+				cmd = s.received[s.cursor]
+				s.cursor++
+			}
+			changed = s.changed
+		})
+		if ok {
+			return
+		}
+		select {
+		case <-changed:
+		case <-timeout:
+			return
+		}
+	}
+}
+
+// Emit sends an event to every authenticated connection that has subscribed to it. Event-Sequence and
+// Event-Date-Timestamp headers are added if absent.
+func (s *Server) Emit(e *Event) {
+	var seq int
+	exclusive(&s.lock, func() {
+		s.sequence++
+		seq = s.sequence
+	})
+	if e.Get("Event-Sequence") == "" {
+		e.Set("Event-Sequence", strconv.Itoa(seq))
+	}
+	if e.Get("Event-Date-Timestamp") == "" {
+		e.Set("Event-Date-Timestamp", strconv.FormatInt(time.Now().UnixNano()/1e3, 10))
+	}
+	for _, c := range s.connections() {
+		if subscribed, format := c.subscribed(e); subscribed {
+			c.send(e.packet(format))
+		}
+	}
+}
+
+// LoseReplies causes the next n replies to commands, from any connection, not to be sent, as if lost in transit.
+// Both command/reply and api/response packets count towards n. Commands are otherwise handled as usual.
+func (s *Server) LoseReplies(n int) {
+	exclusive(&s.lock, func() { s.lose = n })
+}
+
+// Returns true if the next reply should be lost, counting it if so.
+func (s *Server) loseReply() (lost bool) {
+	exclusive(&s.lock, func() {
+		if lost = s.lose > 0; lost {
+			s.lose--
+		}
+	})
+	return
+}
+
+// Drop abruptly closes every connection, as if FreeSWITCH had crashed.
+func (s *Server) Drop() {
+	for _, c := range s.connections() {
+		c.conn.Close()
+	}
+}
+
+// Disconnect sends a disconnection notice to every connection, then closes them.
+func (s *Server) Disconnect() {
+	for _, c := range s.connections() {
+		c.disconnect()
+	}
+}
+
+func (s *Server) connections() (conns []*conn) {
+	exclusive(&s.lock, func() {
+		for c := range s.conns {
+			conns = append(conns, c)
+		}
+	})
+	return
+}
+
+func (s *Server) accept() {
+	for {
+		netConn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		c := &conn{
+			server: s,
+			conn:   netConn,
+			events: map[string]bool{},
+		}
+		var closed bool
+		exclusive(&s.lock, func() {
+			if closed = s.closed; !closed {
+				s.conns[c] = struct{}{}
+			}
+		})
+		if closed {
+			netConn.Close()
+			return
+		}
+		go c.run()
+	}
+}
+
+func (s *Server) record(cmd string) {
+	exclusive(&s.lock, func() {
+		s.received = append(s.received, cmd)
+		close(s.changed)
+		s.changed = make(chan struct{})
+	})
+}
+
+func (s *Server) api(app, args string) string {
+	var handler APIHandler
+	exclusive(&s.lock, func() { handler = s.apis[app] })
+	if handler == nil {
+		return "-ERR " + app + " Command not found!\n"
+	}
+	return handler(args)
+}
+
+func (s *Server) commandHandler(name string) (handler CommandHandler) {
+	exclusive(&s.lock, func() { handler = s.commands[name] })
+	return
+}
+
+func exclusive(lock sync.Locker, f func()) {
+	lock.Lock()
+	defer lock.Unlock()
+	f()
+}
+
+// Read a command from a client, up to and excluding its terminating blank line, and then its body if it has a
+// Content-Length header.
+func readCommand(reader *bufio.Reader) (lines []string, body string, err error) {
+	for {
+		var line string
+		if line, err = reader.ReadString('\n'); err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line != "" {
+			lines = append(lines, line)
+		} else if len(lines) > 0 {
+			break
+		}
+	}
+	if length, _ := strconv.Atoi(headerValue(lines[1:], "Content-Length")); length > 0 {
+		buf := make([]byte, length)
+		if _, err = io.ReadFull(reader, buf); err == nil {
+			body = string(buf)
+		}
+	}
+	return
+}
@@ -1,9 +1,7 @@
 package freeswitch
 
 import (
-	"bufio"
-	"io"
-	"net/textproto"
+	"path"
 	"strconv"
 	"strings"
 	"time"
@@ -15,12 +13,28 @@ type EventName struct {
 	Subclass string
 }
 
+// Header is a single event header, as returned by Event.Headers().
+type Header struct {
+	Name  string
+	Value string
+}
+
+// The encoding of events sent by FreeSWITCH.
+type EventFormat string
+
+const (
+	EventFormatPlain EventFormat = "plain"
+	EventFormatJSON  EventFormat = "json"
+	EventFormatXML   EventFormat = "xml"
+)
+
 // Represents an event raised by FreeSWITCH, and will be passed to your event handlers.
 type Event struct {
 	*rawPacket
 	client  *Client
 	headers headers
 	body    string
+	format  EventFormat
 }
 
 // Returns true if the event name is a custom event (and therefore should have a subclass).
@@ -28,6 +42,24 @@ func (en *EventName) IsCustom() bool {
 	return en.Subclass != ""
 }
 
+// Returns true if the name matches more than one kind of event, i.e. it's "ALL", or a custom subclass containing
+// glob characters.
+func (en *EventName) isWildcard() bool {
+	return en.Name == "ALL" || (en.IsCustom() && strings.ContainsAny(en.Subclass, "*?[\\"))
+}
+
+// Returns true if handlers registered for this name should receive events with the given name.
+func (en *EventName) matches(name *EventName) bool {
+	switch {
+	case en.Name == "ALL":
+		return true
+	case en.IsCustom():
+		matched, _ := path.Match(en.Subclass, name.Subclass)
+		return name.Name == en.Name && matched
+	}
+	return *en == *name
+}
+
 // The name of the event as a string, including the "CUSTOM " prefix for custom events.
 func (en *EventName) String() string {
 	if en.IsCustom() {
@@ -36,8 +68,16 @@ func (en *EventName) String() string {
 	return en.Name
 }
 
-// Returns the name of the event as an EventName.
+// Returns the name of the event as an EventName. For plain events that haven't been decoded, only the name headers
+// are read, so that events can be dispatched without decoding them.
 func (e *Event) Name() *EventName {
+	if rp := e.rawPacket; rp != nil && e.format == EventFormatPlain && !rp.decoded.Load() {
+		block, _ := splitHeaders(rp.body)
+		return &EventName{
+			Name:     peekHeader(block, "Event-Name", true),
+			Subclass: peekHeader(block, "Event-Subclass", true),
+		}
+	}
 	return &EventName{
 		Name:     e.Get("Event-Name"),
 		Subclass: e.Get("Event-Subclass"),
@@ -48,9 +88,6 @@ func (e *Event) Name() *EventName {
 // is not present in the event.
 func (e *Event) Get(name string) string {
 	e.read()
-	if e.headers == nil {
-		return ""
-	}
 	return e.headers.get(name)
 }
 
@@ -58,14 +95,41 @@ func (e *Event) Get(name string) string {
 func (e *Event) Set(name string, value string) *Event {
 	if value != "" {
 		e.read()
-		if e.headers == nil {
-			e.headers = headers{}
-		}
 		e.headers.set(name, value)
 	}
 	return e
 }
 
+// Get all values of the given header, in the order they appear in the event. Names are not case-sensitive.
+func (e *Event) GetAll(name string) []string {
+	e.read()
+	return e.headers.getAll(name)
+}
+
+// Add a value to the event's headers, after any existing values with the same name.
+func (e *Event) Add(name string, value string) *Event {
+	e.read()
+	e.headers.add(name, value)
+	return e
+}
+
+// Delete all values of the given header.
+func (e *Event) Del(name string) *Event {
+	e.read()
+	e.headers.del(name)
+	return e
+}
+
+// Headers returns a copy of the event's headers, in the order FreeSWITCH sent them, including duplicates.
+func (e *Event) Headers() []Header {
+	e.read()
+	h := make([]Header, e.headers.len())
+	for i, original := range e.headers.list {
+		h[i] = Header{original.name, original.value}
+	}
+	return h
+}
+
 // Set the event's body.
 func (e *Event) SetBody(value string) *Event {
 	if value != e.body {
@@ -103,25 +167,80 @@ func (e *Event) Sequence() int {
 	return num
 }
 
-// Dump the event out to a string, as it would be sent to/from FreeSWITCH.
+// The format in which the event was received or loaded. Events made by the client are in EventFormatPlain.
+func (e *Event) Format() EventFormat {
+	if e.format == "" {
+		return EventFormatPlain
+	}
+	return e.format
+}
+
+// Dump the event out to a string, as it would be sent to/from FreeSWITCH, in the event's format.
 func (e *Event) String() string {
+	switch e.Format() {
+	case EventFormatJSON:
+		return e.jsonString()
+	case EventFormatXML:
+		return e.xmlString()
+	default:
+		return e.plainString()
+	}
+}
+
+func (e *Event) plainString() string {
 	e.read()
 	return e.headers.escapedString() + "\n" + e.Body()
 }
 
+// Decode the event's headers and body from its packet, if that hasn't already been done. It's safe to call from
+// several goroutines at once.
 func (e *Event) read() {
-	if e.headers == nil {
-		reader := bufio.NewReader(strings.NewReader(e.rawPacket.body))
-		mimeHeaders, err := textproto.NewReader(reader).ReadMIMEHeader()
-		if err == nil || err == io.EOF {
-			e.headers = loadHeaders(mimeHeaders, true)
-			if contentLength, err := strconv.Atoi(e.headers.get("Content-Length")); err == nil && contentLength > 0 {
-				body := make([]byte, contentLength)
-				io.ReadFull(reader, body)
-				e.body = string(body)
-			}
-		} else {
-			e.headers = headers{&header{"Event-Name", err.Error()}}
+	if e.rawPacket != nil {
+		e.rawPacket.decode.Do(e.decode)
+	}
+}
+
+func (e *Event) decode() {
+	if e.headers.len() == 0 {
+		var err error
+		switch e.Format() {
+		case EventFormatJSON:
+			err = e.readJSON()
+		case EventFormatXML:
+			err = e.readXML()
+		default:
+			err = e.readPlain()
+		}
+		if err != nil {
+			e.headers = makeHeaders(header{"Event-Name", err.Error()})
 		}
 	}
+	e.rawPacket.decoded.Store(true)
+}
+
+func (e *Event) readPlain() error {
+	block, rest := splitHeaders(e.rawPacket.body)
+	headers, err := parseHeaders(block, true)
+	if err != nil {
+		return err
+	}
+	e.headers = headers
+	if contentLength, err := strconv.Atoi(e.headers.get("Content-Length")); err == nil && contentLength > 0 {
+		if contentLength > len(rest) {
+			contentLength = len(rest)
+		}
+		e.body = rest[:contentLength]
+	}
+	return nil
+}
+
+// Guess the format of a serialised event.
+func detectEventFormat(packet string) EventFormat {
+	switch trimmed := strings.TrimSpace(packet); {
+	case strings.HasPrefix(trimmed, "{"):
+		return EventFormatJSON
+	case strings.HasPrefix(trimmed, "<"):
+		return EventFormatXML
+	}
+	return EventFormatPlain
 }
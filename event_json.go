@@ -0,0 +1,108 @@
+package freeswitch
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The key under which FreeSWITCH puts an event's body in text/event-json packets. Event.Decode() uses it to refer
+// to the body too.
+const jsonBodyKey = "_body"
+
+// MarshalJSON encodes the event as FreeSWITCH would in a text/event-json packet: an object of header names to
+// values, with the body under "_body". Headers with several values are encoded as arrays.
+func (e *Event) MarshalJSON() ([]byte, error) {
+	e.read()
+	var (
+		buf     = &bytes.Buffer{}
+		written = map[string]bool{}
+	)
+	buf.WriteByte('{')
+	writeJSON := func(name string, value interface{}) {
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(name)
+		val, _ := json.Marshal(value)
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(val)
+	}
+	for _, h := range e.headers.list {
+		name := strings.ToLower(h.name)
+		if written[name] {
+			continue
+		}
+		written[name] = true
+		if values := e.headers.getAll(h.name); len(values) > 1 {
+			writeJSON(h.name, values)
+		} else {
+			writeJSON(h.name, h.value)
+		}
+	}
+	if e.body != "" {
+		writeJSON(jsonBodyKey, e.body)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func (e *Event) jsonString() string {
+	b, _ := e.MarshalJSON()
+	return string(b)
+}
+
+// Decode a text/event-json body, preserving the order of its headers.
+func (e *Event) readJSON() error {
+	decoder := json.NewDecoder(strings.NewReader(e.rawPacket.body))
+	decoder.UseNumber()
+	if token, err := decoder.Token(); err != nil {
+		return err
+	} else if token != json.Delim('{') {
+		return fmt.Errorf("expected JSON object, got %v", token)
+	}
+	h := headers{}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		name, _ := token.(string)
+		var value interface{}
+		if err = decoder.Decode(&value); err != nil {
+			return err
+		}
+		values, isArray := value.([]interface{})
+		if !isArray {
+			values = []interface{}{value}
+		}
+		for _, value := range values {
+			str := jsonString(value)
+			if name == jsonBodyKey {
+				e.body = str
+			} else {
+				h.add(name, str)
+			}
+		}
+	}
+	if e.body != "" && h.get("Content-Length") == "" {
+		h.add("Content-Length", strconv.Itoa(len(e.body)))
+	}
+	e.headers = h
+	return nil
+}
+
+// Convert a decoded JSON value to a header value. FreeSWITCH only sends strings, but be lenient with other types.
+func jsonString(value interface{}) string {
+	switch value := value.(type) {
+	case string:
+		return value
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(value)
+	}
+}
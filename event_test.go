@@ -0,0 +1,163 @@
+package freeswitch
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const plainHeartbeat = "Event-Name: HEARTBEAT\n" +
+	"Core-UUID: 8b2c5e3a-0d8e-4a0c-b2b5-3f0f0b1a6c2d\n" +
+	"Event-Info: System%20Ready\n" +
+	"Content-Length: 11\n" +
+	"\n" +
+	"hello world"
+
+func TestEvent_json(t *testing.T) {
+	c := newClient()
+	e := c.LoadEvent(`{"Event-Name":"HEARTBEAT","Up-Time":"0 years","Event-Sequence":42,` +
+		`"Multi":["a","b"],"_body":"hello world"}`)
+	Equals(t, EventFormatJSON, e.Format())
+	Equals(t, "HEARTBEAT", e.Name().Name)
+	Equals(t, "0 years", e.Get("Up-Time"))
+	Equals(t, 42, e.Sequence())
+	Equals(t, []string{"a", "b"}, e.headers.getAll("Multi"))
+	Equals(t, "hello world", e.Body())
+	Equals(t, "11", e.Get("Content-Length"))
+
+	// Round trip
+	loaded := c.LoadEvent(e.String())
+	Equals(t, EventFormatJSON, loaded.Format())
+	Equals(t, e.String(), loaded.String())
+	Equals(t, []string{"a", "b"}, loaded.headers.getAll("Multi"))
+}
+
+func TestEvent_plain(t *testing.T) {
+	c := newClient()
+	e := c.LoadEvent(plainHeartbeat)
+	Equals(t, EventFormatPlain, e.Format())
+	Equals(t, "System Ready", e.Get("Event-Info"))
+	Equals(t, "hello world", e.Body())
+
+	// Round trip
+	loaded := c.LoadEvent(e.String())
+	Equals(t, EventFormatPlain, loaded.Format())
+	Equals(t, e.String(), loaded.String())
+	Equals(t, plainHeartbeat, loaded.String())
+}
+
+func TestEvent_Headers(t *testing.T) {
+	c := newClient()
+	e := c.LoadEvent("Event-Name: CUSTOM\n" +
+		"Event-Subclass: sofia::register\n" +
+		"contact: %3Csip:1000@10.0.0.1%3E\n" +
+		"Contact: %3Csip:1000@10.0.0.2%3E\n\n")
+	Equals(t, []Header{
+		{"Event-Name", "CUSTOM"},
+		{"Event-Subclass", "sofia::register"},
+		{"contact", "<sip:1000@10.0.0.1>"},
+		{"Contact", "<sip:1000@10.0.0.2>"},
+	}, e.Headers())
+	Equals(t, []string{"<sip:1000@10.0.0.1>", "<sip:1000@10.0.0.2>"}, e.GetAll("CONTACT"))
+
+	e.Add("contact", "<sip:1000@10.0.0.3>").Del("Event-Subclass")
+	Equals(t, []Header{
+		{"Event-Name", "CUSTOM"},
+		{"contact", "<sip:1000@10.0.0.1>"},
+		{"Contact", "<sip:1000@10.0.0.2>"},
+		{"contact", "<sip:1000@10.0.0.3>"},
+	}, e.Headers())
+}
+
+func TestEvent_xml(t *testing.T) {
+	c := newClient()
+	e := c.LoadEvent("<event>\n" +
+		"  <headers>\n" +
+		"    <Event-Name>HEARTBEAT</Event-Name>\n" +
+		"    <Event-Info>System Ready &amp; Waiting</Event-Info>\n" +
+		"    <Event-Date-Local>2024-01-01%2012%3A00%3A00</Event-Date-Local>\n" +
+		"  </headers>\n" +
+		"  <body>hello world</body>\n" +
+		"</event>")
+	Equals(t, EventFormatXML, e.Format())
+	Equals(t, "HEARTBEAT", e.Name().Name)
+	Equals(t, "System Ready & Waiting", e.Get("Event-Info"))
+	Equals(t, "2024-01-01 12:00:00", e.Get("Event-Date-Local"))
+	Equals(t, "hello world", e.Body())
+	Assert(t, strings.Contains(e.String(), "<Event-Date-Local>2024-01-01%2012:00:00</Event-Date-Local>"),
+		"expected header value to be encoded")
+
+	// Round trip
+	loaded := c.LoadEvent(e.String())
+	Equals(t, EventFormatXML, loaded.Format())
+	Equals(t, e.String(), loaded.String())
+	Equals(t, "System Ready & Waiting", loaded.Get("Event-Info"))
+
+	// Conversion from other formats
+	plain := c.LoadEvent(plainHeartbeat)
+	Equals(t, "hello world", c.LoadEvent(plain.xmlString()).Body())
+}
+
+func TestEvent_Decode(t *testing.T) {
+	c := newClient()
+	e := c.LoadEvent("Event-Name: DTMF\n" +
+		"Event-Sequence: 512\n" +
+		"Event-Date-Timestamp: 1588432483512347\n" +
+		"Unique-ID: 0d5b5c2a-8f4c-4d8c-9a6e-2b7e3c1f4a10\n" +
+		"Channel-State-Number: 4\n" +
+		"Caller-Caller-ID-Name: Jo%20Bloggs\n" +
+		"Caller-Channel-Answered-Time: 1588432480000000\n" +
+		"Caller-Channel-Hangup-Time: 0\n" +
+		"Other-Leg-Unique-ID: 3e9d4c1b-7a2f-4b6e-8c5d-1f0a2b3c4d5e\n" +
+		"variable_sip_from_user: 1000\n" +
+		"variable_hold_music: local_stream://moh\n" +
+		"DTMF-Digit: 5\n" +
+		"DTMF-Duration: 2000\n\n")
+
+	var dtmf DTMFEvent
+	Equals(t, nil, e.Decode(&dtmf))
+	Equals(t, "DTMF", dtmf.Name)
+	Equals(t, 512, dtmf.Sequence)
+	Equals(t, time.Unix(1588432483, 512347000), dtmf.Timestamp)
+	Equals(t, "0d5b5c2a-8f4c-4d8c-9a6e-2b7e3c1f4a10", dtmf.UniqueID)
+	Equals(t, 4, dtmf.StateNumber)
+	Equals(t, "Jo Bloggs", dtmf.Caller.CallerIDName)
+	Equals(t, time.Unix(1588432480, 0), dtmf.Caller.ChannelAnsweredTime)
+	Equals(t, true, dtmf.Caller.ChannelHangupTime.IsZero())
+	Equals(t, "3e9d4c1b-7a2f-4b6e-8c5d-1f0a2b3c4d5e", dtmf.OtherLeg.UniqueID)
+	Equals(t, Variables{"sip_from_user": "1000", "hold_music": "local_stream://moh"}, dtmf.Variables)
+	Equals(t, "5", dtmf.Digit)
+	Equals(t, 2000, dtmf.Duration)
+
+	var custom struct {
+		Answered bool   `fs:"Answered"`
+		Body     string `fs:"_body"`
+		Ignored  string
+	}
+	e = c.LoadEvent("Event-Name: CUSTOM\nAnswered: yes\nContent-Length: 2\n\nhi")
+	Equals(t, nil, e.Decode(&custom))
+	Equals(t, true, custom.Answered)
+	Equals(t, "hi", custom.Body)
+
+	var invalid struct {
+		Count int `fs:"Count"`
+	}
+	Assert(t, c.LoadEvent("Event-Name: CUSTOM\nCount: many\n\n").Decode(&invalid) != nil, "expected an error")
+	Assert(t, e.Decode(custom) != nil, "expected an error for a non-pointer")
+}
+
+func TestEvent_Variables(t *testing.T) {
+	c := newClient()
+	e := c.LoadEvent("Event-Name: CHANNEL_DATA\n" +
+		"variable_sip_from_user: 1000\n" +
+		"variable_sip_h_X-Tags: ARRAY::red|:green\n" +
+		"Caller-Username: 1000\n\n")
+	Equals(t, Variables{"sip_from_user": "1000", "sip_h_X-Tags": "ARRAY::red|:green"}, e.Variables())
+	Equals(t, "1000", e.Variable("sip_from_user"))
+	Equals(t, []string{"red", "green"}, e.Variables().Array("sip_h_X-Tags"))
+	Equals(t, []string{"1000"}, ParseArray(e.Variable("sip_from_user")))
+	Equals(t, []string(nil), ParseArray(e.Variable("missing")))
+
+	e.SetVariable("colours", FormatArray("blue", "yellow"))
+	Equals(t, "ARRAY::blue|:yellow", e.Get("variable_colours"))
+}
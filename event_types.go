@@ -0,0 +1,127 @@
+package freeswitch
+
+import "time"
+
+// EventHeader holds the headers common to all events. It is embedded in the other typed events. See Event.Decode().
+type EventHeader struct {
+	Name              string    `fs:"Event-Name"`
+	Subclass          string    `fs:"Event-Subclass"`
+	Sequence          int       `fs:"Event-Sequence"`
+	Timestamp         time.Time `fs:"Event-Date-Timestamp"`
+	CoreUUID          string    `fs:"Core-UUID"`
+	Hostname          string    `fs:"FreeSWITCH-Hostname"`
+	Switchname        string    `fs:"FreeSWITCH-Switchname"`
+	IPv4              string    `fs:"FreeSWITCH-IPv4"`
+	IPv6              string    `fs:"FreeSWITCH-IPv6"`
+	CallingFile       string    `fs:"Event-Calling-File"`
+	CallingFunction   string    `fs:"Event-Calling-Function"`
+	CallingLineNumber int       `fs:"Event-Calling-Line-Number"`
+}
+
+// CallerProfile is a channel's caller profile, found in channel events with a prefix, e.g. "Caller-" or
+// "Other-Leg-".
+type CallerProfile struct {
+	Direction           string    `fs:"Direction"`
+	Username            string    `fs:"Username"`
+	Dialplan            string    `fs:"Dialplan"`
+	CallerIDName        string    `fs:"Caller-ID-Name"`
+	CallerIDNumber      string    `fs:"Caller-ID-Number"`
+	OrigCallerIDName    string    `fs:"Orig-Caller-ID-Name"`
+	OrigCallerIDNumber  string    `fs:"Orig-Caller-ID-Number"`
+	CalleeIDName        string    `fs:"Callee-ID-Name"`
+	CalleeIDNumber      string    `fs:"Callee-ID-Number"`
+	NetworkAddr         string    `fs:"Network-Addr"`
+	ANI                 string    `fs:"ANI"`
+	DestinationNumber   string    `fs:"Destination-Number"`
+	UniqueID            string    `fs:"Unique-ID"`
+	Source              string    `fs:"Source"`
+	Context             string    `fs:"Context"`
+	ChannelName         string    `fs:"Channel-Name"`
+	ProfileCreatedTime  time.Time `fs:"Profile-Created-Time"`
+	ChannelCreatedTime  time.Time `fs:"Channel-Created-Time"`
+	ChannelAnsweredTime time.Time `fs:"Channel-Answered-Time"`
+	ChannelHangupTime   time.Time `fs:"Channel-Hangup-Time"`
+}
+
+// ChannelEvent holds the headers of CHANNEL_* events, and of other events raised for a channel.
+type ChannelEvent struct {
+	EventHeader
+
+	UniqueID      string `fs:"Unique-ID"`
+	ChannelName   string `fs:"Channel-Name"`
+	State         string `fs:"Channel-State"`
+	StateNumber   int    `fs:"Channel-State-Number"`
+	CallState     string `fs:"Channel-Call-State"`
+	AnswerState   string `fs:"Answer-State"`
+	CallDirection string `fs:"Call-Direction"`
+	CallUUID      string `fs:"Channel-Call-UUID"`
+	PresenceID    string `fs:"Channel-Presence-ID"`
+	HangupCause   string `fs:"Hangup-Cause"`
+	ReadCodec     string `fs:"Channel-Read-Codec-Name"`
+	ReadRate      int    `fs:"Channel-Read-Codec-Rate"`
+	WriteCodec    string `fs:"Channel-Write-Codec-Name"`
+	WriteRate     int    `fs:"Channel-Write-Codec-Rate"`
+
+	// The channel's caller profile.
+	Caller CallerProfile `fs:"Caller-"`
+
+	// The caller profile of the channel to which this one is bridged or being bridged, if any.
+	OtherLeg CallerProfile `fs:"Other-Leg-"`
+
+	// Channel variables, with their "variable_" prefixes removed. Only present in events from channels with
+	// verbose events enabled, or in CHANNEL_DATA.
+	Variables Variables `fs:"variable_*"`
+}
+
+// HeartbeatEvent holds the headers of HEARTBEAT events.
+type HeartbeatEvent struct {
+	EventHeader
+
+	Info                 string  `fs:"Event-Info"`
+	Version              string  `fs:"FreeSWITCH-Version"`
+	UpTime               string  `fs:"Up-Time"`
+	UptimeMsec           int64   `fs:"Uptime-msec"`
+	SessionCount         int     `fs:"Session-Count"`
+	MaxSessions          int     `fs:"Max-Sessions"`
+	SessionPerSec        int     `fs:"Session-Per-Sec"`
+	SessionPerSecLast    int     `fs:"Session-Per-Sec-Last"`
+	SessionPerSecMax     int     `fs:"Session-Per-Sec-Max"`
+	SessionPerSecFiveMin int     `fs:"Session-Per-Sec-FiveMin"`
+	SessionSinceStartup  int64   `fs:"Session-Since-Startup"`
+	SessionPeakMax       int     `fs:"Session-Peak-Max"`
+	SessionPeakFiveMin   int     `fs:"Session-Peak-FiveMin"`
+	IdleCPU              float64 `fs:"Idle-CPU"`
+}
+
+// BackgroundJobEvent holds the headers and result of BACKGROUND_JOB events.
+type BackgroundJobEvent struct {
+	EventHeader
+
+	JobUUID string `fs:"Job-UUID"`
+	Command string `fs:"Job-Command"`
+	Args    string `fs:"Job-Command-Arg"`
+
+	// The result of the command, from the event's body. See ParseResult().
+	Result string `fs:"_body"`
+}
+
+// DTMFEvent holds the headers of DTMF events.
+type DTMFEvent struct {
+	ChannelEvent
+
+	Digit    string `fs:"DTMF-Digit"`
+	Duration int    `fs:"DTMF-Duration"` // in samples
+	Source   string `fs:"DTMF-Source"`
+}
+
+// CallUpdateEvent holds the headers of CALL_UPDATE events, raised when a call's caller ID or bridged party changes.
+type CallUpdateEvent struct {
+	ChannelEvent
+
+	Direction        string `fs:"Direction"`
+	CalleeName       string `fs:"Callee-Name"`
+	CalleeNumber     string `fs:"Callee-Number"`
+	SentCalleeName   string `fs:"Sent-Callee-Name"`
+	SentCalleeNumber string `fs:"Sent-Callee-Number"`
+	BridgedTo        string `fs:"Bridged-To"`
+}
@@ -0,0 +1,100 @@
+package freeswitch
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// MarshalXML encodes the event as FreeSWITCH would in a text/event-xml packet, e.g.:
+//
+//	<event>
+//	  <headers>
+//	    <Event-Name>HEARTBEAT</Event-Name>
+//	    ...etc
+//	  </headers>
+//	  <body>...</body>
+//	</event>
+//
+// Like FreeSWITCH, header values are percent-encoded. The body element is omitted if the event has no body.
+func (e *Event) MarshalXML(encoder *xml.Encoder, start xml.StartElement) error {
+	e.read()
+	start.Name = xml.Name{Local: "event"}
+	headersElement := xml.StartElement{Name: xml.Name{Local: "headers"}}
+	tokens := []xml.Token{start, headersElement}
+	for _, h := range e.headers.list {
+		name := xml.Name{Local: h.name}
+		tokens = append(tokens, xml.StartElement{Name: name}, xml.CharData(h.escapedValue()), xml.EndElement{Name: name})
+	}
+	tokens = append(tokens, headersElement.End())
+	if e.body != "" {
+		bodyElement := xml.StartElement{Name: xml.Name{Local: "body"}}
+		tokens = append(tokens, bodyElement, xml.CharData(e.body), bodyElement.End())
+	}
+	tokens = append(tokens, start.End())
+	for _, token := range tokens {
+		if err := encoder.EncodeToken(token); err != nil {
+			return err
+		}
+	}
+	return encoder.Flush()
+}
+
+func (e *Event) xmlString() string {
+	buf := &bytes.Buffer{}
+	encoder := xml.NewEncoder(buf)
+	encoder.Indent("", "  ")
+	encoder.Encode(e)
+	return buf.String()
+}
+
+// Decode a text/event-xml body, preserving the order of its headers. FreeSWITCH percent-encodes header values, but
+// not the body.
+func (e *Event) readXML() error {
+	var (
+		decoder = xml.NewDecoder(strings.NewReader(e.rawPacket.body))
+		h       = headers{}
+		path    []string
+		text    strings.Builder
+	)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		switch token := token.(type) {
+		case xml.StartElement:
+			path = append(path, token.Name.Local)
+			text.Reset()
+		case xml.CharData:
+			text.Write(token)
+		case xml.EndElement:
+			switch {
+			case len(path) == 3 && path[0] == "event" && path[1] == "headers":
+				value, err := url.PathUnescape(text.String())
+				if err != nil {
+					value = text.String()
+				}
+				h.add(path[2], value)
+			case len(path) == 2 && path[0] == "event" && path[1] == "body":
+				e.body = text.String()
+			}
+			path = path[:len(path)-1]
+			text.Reset()
+		}
+	}
+	if h.len() == 0 {
+		return fmt.Errorf("no event headers found in XML")
+	}
+	if e.body != "" && h.get("Content-Length") == "" {
+		h.add("Content-Length", strconv.Itoa(len(e.body)))
+	}
+	e.headers = h
+	return nil
+}
@@ -0,0 +1,259 @@
+package freeswitch
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// A server-side event filter. See Client.Filter().
+type eventFilter struct {
+	header  string
+	value   string
+	pattern *regexp.Regexp // the compiled value, if it's a regular expression and the filter is matched by the client
+}
+
+// A filter installed by Filter() or OnFiltered(), and what depends on it.
+type filterUse struct {
+	eventFilter
+	explicit bool // added by Filter()
+	handlers int  // the number of handlers added by OnFiltered() that haven't been cancelled
+}
+
+// Installed alongside other filters, so that results of background jobs still arrive. See Query().
+var jobFilter = eventFilter{header: "Event-Name", value: "BACKGROUND_JOB"}
+
+// Make a filter that can be matched by the client. Like FreeSWITCH, values enclosed in slashes are treated as regular
+// expressions, which are compiled here, so that an invalid one is reported rather than failing to match.
+func newEventFilter(header, value string) (f eventFilter, err error) {
+	f = eventFilter{header: header, value: value}
+	if len(value) > 1 && strings.HasPrefix(value, "/") && strings.HasSuffix(value, "/") {
+		f.pattern, err = regexp.Compile(value[1 : len(value)-1])
+	}
+	return
+}
+
+// Returns true if the given event would pass the filter, which must have been made by newEventFilter().
+func (f eventFilter) matches(e *Event) bool {
+	actual := e.Get(f.header)
+	if f.pattern != nil {
+		return f.pattern.MatchString(actual)
+	}
+	return actual == f.value
+}
+
+// Returns true if the filters have the same header and value.
+func (f eventFilter) is(other eventFilter) bool {
+	return f.header == other.header && f.value == other.value
+}
+
+// Filter asks FreeSWITCH to only send events whose given header has the given value, e.g. Filter("Unique-ID", uuid).
+// Values enclosed in slashes are regular expressions, e.g. "/^1000$/".
+//
+// Once any filter is installed, FreeSWITCH only sends events that match at least one filter, which affects all of
+// the client's handlers. BACKGROUND_JOB events are always let through, so that Query() keeps working. Filters are
+// installed when the client connects, and restored when it reconnects. If the client is connected, the filter is
+// installed immediately, and an error is returned if FreeSWITCH rejects it.
+func (c *Client) Filter(header, value string) error {
+	return c.useFilter(eventFilter{header: header, value: value}, true)
+}
+
+// RemoveFilter removes a filter added with Filter(). If value is empty, all filters on the given header are removed.
+// Filters are removed even if handlers added with OnFiltered() depend on them.
+func (c *Client) RemoveFilter(header, value string) (err error) {
+	var last bool
+	if !c.reconfigure(func() {
+		filters := c.filters[:0]
+		for _, existing := range c.filters {
+			if existing.header != header || (value != "" && existing.value != value) {
+				filters = append(filters, existing)
+			}
+		}
+		last = len(filters) == 0 && len(c.filters) > 0
+		c.filters = filters
+	}) {
+		return
+	}
+	if value == "" {
+		err = c.configure("filter", "delete", header)
+	} else {
+		err = c.configure("filter", "delete", header, value)
+	}
+	if err == nil && last {
+		err = c.configure("filter", "delete", jobFilter.header, jobFilter.value)
+	}
+	return
+}
+
+// Add a user of the given filter, installing it if it's the first. Explicit users are those of Filter(), of which
+// there is at most one; others are handlers added by OnFiltered().
+func (c *Client) useFilter(filter eventFilter, explicit bool) error {
+	added, first := false, false
+	connected := c.reconfigure(func() {
+		use := c.findFilter(filter)
+		if use == nil {
+			first = len(c.filters) == 0
+			use = &filterUse{eventFilter: filter}
+			c.filters = append(c.filters, use)
+			added = true
+		}
+		if explicit {
+			use.explicit = true
+		} else {
+			use.handlers++
+		}
+	})
+	if !added || !connected {
+		return nil
+	}
+	if first {
+		if err := c.configure("filter", jobFilter.header, jobFilter.value); err != nil {
+			return err
+		}
+	}
+	return c.configure("filter", filter.header, filter.value)
+}
+
+// Remove a handler's use of the given filter, deleting the filter if nothing else uses it.
+func (c *Client) releaseFilter(filter eventFilter) (err error) {
+	deleted, last := false, false
+	connected := c.reconfigure(func() {
+		use := c.findFilter(filter)
+		if use == nil {
+			return // Already removed by RemoveFilter()
+		}
+		if use.handlers--; use.explicit || use.handlers > 0 {
+			return
+		}
+		filters := c.filters[:0]
+		for _, existing := range c.filters {
+			if existing != use {
+				filters = append(filters, existing)
+			}
+		}
+		c.filters = filters
+		deleted, last = true, len(filters) == 0
+	})
+	if !deleted || !connected {
+		return
+	}
+	err = c.configure("filter", "delete", filter.header, filter.value)
+	if err == nil && last {
+		err = c.configure("filter", "delete", jobFilter.header, jobFilter.value)
+	}
+	return
+}
+
+// Returns the use of the given filter, or nil if it isn't installed. Call with the control lock held.
+func (c *Client) findFilter(filter eventFilter) *filterUse {
+	for _, use := range c.filters {
+		if use.is(filter) {
+			return use
+		}
+	}
+	return nil
+}
+
+// MyEvents asks FreeSWITCH to only send events for the channel with the given UUID. Like filters, it is applied when
+// the client connects, and restored when it reconnects. It can't be undone while connected. Results of background
+// jobs don't belong to a channel, so won't be received; consider Filter() instead if you need Query().
+func (c *Client) MyEvents(uuid string) error {
+	if !c.reconfigure(func() { c.myEvents = uuid }) {
+		return nil
+	}
+	return c.configure(myEventsCommand(c.EventFormat, uuid)...)
+}
+
+// DivertEvents turns on or off the diversion of events from a channel's embedded script (e.g. Lua) to the event
+// socket. Like filters, it is applied when the client connects, and restored when it reconnects.
+func (c *Client) DivertEvents(enabled bool) error {
+	if !c.reconfigure(func() { c.divertEvents = enabled }) {
+		return nil
+	}
+	return c.configure(divertEventsCommand(enabled))
+}
+
+// OnFiltered handles the given event with the given handler, but only when the event's given header has the given
+// value. A matching server-side filter is also installed (see Filter()), so FreeSWITCH doesn't send events the
+// handler would ignore. For CUSTOM events, use the name "CUSTOM" followed by a space and the subclass.
+//
+// The returned function removes the handler, and the filter once nothing else depends on it. See On(). An error is
+// returned if the value is an invalid regular expression, in which case the handler isn't added, or if the client is
+// connected, and FreeSWITCH rejects the subscription or the filter.
+func (c *Client) OnFiltered(header, value, eventName string, handler EventHandler) (cancel func(), err error) {
+	filter, err := newEventFilter(header, value)
+	if err != nil {
+		return func() {}, err
+	}
+	off, err := c.on(parseEventName(eventName), func(e *Event) {
+		if filter.matches(e) {
+			handler(e)
+		}
+	})
+	if filterErr := c.useFilter(filter, false); err == nil {
+		err = filterErr
+	}
+	var once sync.Once
+	cancel = func() {
+		once.Do(func() {
+			off()
+			c.releaseFilter(filter)
+		})
+	}
+	return
+}
+
+// Make a change to the configuration installed by restoreConfiguration(), and return true if the client is connected,
+// in which case the caller must also send the change to FreeSWITCH. A handshake holds the control lock from when the
+// client starts running until the configuration is restored, so each change is either restored or sent, not both.
+func (c *Client) reconfigure(change func()) (connected bool) {
+	exclusive(&c.control, func() {
+		change()
+		connected = c.isRunning()
+	})
+	return
+}
+
+// Send a command that changes the connection's configuration. See reconfigure().
+func (c *Client) configure(args ...string) (err error) {
+	_, err = c.sendCommand(strings.Join(args, " "))
+	return
+}
+
+// Install filters and other settings made before connecting, or before a reconnection.
+func (c *Client) restoreConfiguration(h *handshake) {
+	if c.divertEvents {
+		h.send(divertEventsCommand(true))
+		h.expectOK(ECommandFailed)
+	}
+	if c.myEvents != "" {
+		h.send(myEventsCommand(c.EventFormat, c.myEvents)...)
+		h.expectOK(ECommandFailed)
+	}
+	if len(c.filters) > 0 {
+		h.send("filter", jobFilter.header, jobFilter.value)
+		h.expectOK(ECommandFailed)
+	}
+	for _, filter := range c.filters {
+		h.send("filter", filter.header, filter.value)
+		h.expectOK(ECommandFailed)
+	}
+}
+
+// The "myevents" command for the channel with the given UUID, or if it's empty, for an outbound session's own channel.
+func myEventsCommand(format EventFormat, uuid string) []string {
+	if format == "" {
+		format = EventFormatPlain
+	}
+	if uuid == "" {
+		return []string{"myevents", string(format)}
+	}
+	return []string{"myevents", string(format), uuid}
+}
+
+func divertEventsCommand(enabled bool) string {
+	if enabled {
+		return "divert_events on"
+	}
+	return "divert_events off"
+}
@@ -0,0 +1,45 @@
+package freeswitch
+
+import (
+	"context"
+	"time"
+)
+
+// Tracks the opening exchange of a connection, all of which must complete within a single timeout, and before its
+// context is done. Once an error occurs, subsequent steps are skipped.
+type handshake struct {
+	ctx     context.Context
+	client  *Client
+	timeout <-chan time.Time
+	err     error
+}
+
+// Wait for a packet within the handshake timeout.
+func (h *handshake) next(handler func(*rawPacket)) {
+	if h.err == nil {
+		select {
+		case packet := <-h.client.inbox:
+			handler(packet)
+		case <-h.timeout:
+			h.err = ETimeout
+		case <-h.ctx.Done():
+			h.err = h.ctx.Err()
+		}
+	}
+}
+
+// Expect an OK response, setting the given error if one is not received.
+func (h *handshake) expectOK(onFail error) {
+	h.next(func(response *rawPacket) {
+		if result, ok := response.cast().(*reply); !ok || !result.ok() {
+			h.err = onFail
+		}
+	})
+}
+
+// Write a command to the connection.
+func (h *handshake) send(cmd ...string) {
+	if h.err == nil {
+		h.err = h.client.write(cmd...)
+	}
+}
@@ -1,13 +1,21 @@
 package freeswitch
 
 import (
-	"net/textproto"
+	"errors"
 	"net/url"
+	"sort"
 	"strings"
 )
 
-// A collection of event headers.
-type headers []*header
+// A collection of event headers, in the order they were added, including duplicates. Names are not case-sensitive.
+// Large collections are indexed by name, so that lookups don't scan every header. Reading from headers never
+// modifies them, so they can be read from several goroutines at once.
+type headers struct {
+	list   []header
+	index  map[string]int // positions in chains by lower-cased name; nil if there are fewer than headerIndexThreshold
+	chains []headerChain  // kept apart from index, so that they can be adjusted without writing to the map
+	next   []int          // for each header, the position of the next with the same name, or -1
+}
 
 // Represents a single header in an event.
 type header struct {
@@ -15,53 +23,205 @@ type header struct {
 	value string
 }
 
+// The positions of the first and last headers with a particular name, or -1 if none remain.
+type headerChain struct {
+	first, last int
+}
+
+// Collections with fewer headers than this are scanned rather than indexed.
+const headerIndexThreshold = 16
+
+func makeHeaders(list ...header) headers {
+	h := headers{list: list}
+	h.reindex()
+	return h
+}
+
+func (h *headers) len() int {
+	return len(h.list)
+}
+
 func (h *headers) add(name, value string) {
-	n := append(*h, &header{name, value})
-	*h = n
+	h.list = append(h.list, header{name, value})
+	if h.index != nil {
+		h.link(len(h.list)-1, lowerASCII(name))
+	} else if len(h.list) >= headerIndexThreshold {
+		h.reindex()
+	}
 }
 
+// Replace the value of the first header with the given name, and remove any others, or add it if it's absent.
 func (h *headers) set(name, value string) {
-	h.del(name)
-	h.add(name, value)
+	first := h.find(name)
+	if first < 0 {
+		h.add(name, value)
+		return
+	}
+	h.list[first].value = value
+	if next := h.nextOf(first, name); next >= 0 {
+		h.remove(name, next)
+	}
 }
 
-func (h headers) get(name string) string {
-	for _, header := range h {
-		if header.matchName(name) {
-			return header.value
-		}
+func (h *headers) get(name string) string {
+	if i := h.find(name); i >= 0 {
+		return h.list[i].value
 	}
 	return ""
 }
 
 func (h *headers) del(name string) {
-	if count := len(h.getAll(name)); count > 0 {
-		o := *h
-		n := make(headers, 0, len(o)-count)
-		for _, header := range o {
-			if !header.matchName(name) {
-				n = append(n, header)
-			}
+	if i := h.find(name); i >= 0 {
+		h.remove(name, i)
+	}
+}
+
+func (h *headers) getAll(name string) (values []string) {
+	for i := h.find(name); i >= 0; i = h.nextOf(i, name) {
+		values = append(values, h.list[i].value)
+	}
+	return
+}
+
+// Returns a copy of the headers that can be changed without affecting the original.
+func (h *headers) clone() headers {
+	return makeHeaders(append([]header(nil), h.list...)...)
+}
+
+// Returns the position of the first header with the given name, or -1.
+func (h *headers) find(name string) int {
+	if h.index != nil {
+		var buf [64]byte
+		if c, ok := h.index[string(appendLowerASCII(buf[:0], name))]; ok {
+			return h.chains[c].first
 		}
-		*h = n
+		return -1
+	}
+	return h.scan(name, 0)
+}
+
+// Returns the position of the next header after i with the given name, or -1.
+func (h *headers) nextOf(i int, name string) int {
+	if h.index != nil {
+		return h.next[i]
 	}
+	return h.scan(name, i+1)
 }
 
-func (h headers) getAll(name string) (values []string) {
-	for _, header := range h {
-		if header.matchName(name) {
-			values = append(values, header.value)
+func (h *headers) scan(name string, from int) int {
+	for i := from; i < len(h.list); i++ {
+		if h.list[i].matchName(name) {
+			return i
 		}
 	}
-	return
+	return -1
+}
+
+// Remove the header at position i, and any others after it with the same name. The index is adjusted rather than
+// rebuilt, which would allocate. See BenchmarkHeaders_Del.
+func (h *headers) remove(name string, i int) {
+	if h.index == nil {
+		h.compact(name, i)
+		return
+	}
+	var buf [8]int
+	removed := buf[:0]
+	for j := i; j >= 0; j = h.next[j] {
+		removed = append(removed, j)
+	}
+	if len(h.list)-len(removed) < headerIndexThreshold {
+		h.compact(name, i)
+		h.index, h.chains, h.next = nil, nil, nil
+		return
+	}
+	for j, r := 0, 0; j < len(h.next); j++ {
+		if r < len(removed) && removed[r] == j {
+			r++
+		} else if next := h.next[j]; next >= 0 {
+			h.next[j-r] = movedPosition(next, removed)
+		} else {
+			h.next[j-r] = -1
+		}
+	}
+	h.next = h.next[:len(h.next)-len(removed)]
+	var key [64]byte
+	k := appendLowerASCII(key[:0], name)
+	c := h.index[string(k)]
+	if chain := &h.chains[c]; chain.first == i {
+		delete(h.index, string(k))
+		*chain = headerChain{-1, -1}
+	} else {
+		// Only the first header with this name is being kept.
+		h.next[chain.first] = -1
+		chain.last = chain.first
+	}
+	// Only chains ending after i can have moved.
+	for c := range h.chains {
+		if chain := &h.chains[c]; chain.last > i {
+			*chain = headerChain{movedPosition(chain.first, removed), movedPosition(chain.last, removed)}
+		}
+	}
+	h.compact(name, i)
+}
+
+// The new position of the header at position i, which isn't being removed, after removing the headers at the given
+// sorted positions.
+func movedPosition(i int, removed []int) int {
+	return i - sort.SearchInts(removed, i)
+}
+
+// Remove headers with the given name from the list, from position i onwards, without updating the index.
+func (h *headers) compact(name string, i int) {
+	n := i
+	for ; i < len(h.list); i++ {
+		if !h.list[i].matchName(name) {
+			h.list[n] = h.list[i]
+			n++
+		}
+	}
+	for i := n; i < len(h.list); i++ {
+		h.list[i] = header{} // release removed strings
+	}
+	h.list = h.list[:n]
+}
+
+// Rebuild the index, or drop it if there are too few headers to need it. The existing map and slice are reused.
+func (h *headers) reindex() {
+	if len(h.list) < headerIndexThreshold {
+		h.index, h.chains, h.next = nil, nil, nil
+		return
+	}
+	if h.index == nil {
+		h.index = make(map[string]int, len(h.list))
+	} else {
+		for name := range h.index {
+			delete(h.index, name)
+		}
+	}
+	h.chains, h.next = h.chains[:0], h.next[:0]
+	for i := range h.list {
+		h.link(i, lowerASCII(h.list[i].name))
+	}
+}
+
+// Add the header at position i, whose lower-cased name is key, to the index.
+func (h *headers) link(i int, key string) {
+	h.next = append(h.next, -1)
+	if c, ok := h.index[key]; ok {
+		h.next[h.chains[c].last] = i
+		h.chains[c].last = i
+	} else {
+		h.index[key] = len(h.chains)
+		h.chains = append(h.chains, headerChain{i, i})
+	}
 }
 
 // Returns headers in plain text format, e.g.:
 //	Event-Date-Local: 2018-05-04 04:06:45\n
 //	Event-Sequence: 79878\n
 //	...etc
-func (h headers) String() (str string) {
-	for _, header := range h {
+func (h *headers) String() (str string) {
+	for _, header := range h.list {
 		str += header.String()
 	}
 	return
@@ -71,26 +231,46 @@ func (h headers) String() (str string) {
 //	Event-Date-Local: 2018-05-04%2004%3A06%3A45\n
 //	Event-Sequence: 79878\n
 //	...etc
-func (h headers) escapedString() (str string) {
-	for _, header := range h {
+func (h *headers) escapedString() (str string) {
+	for _, header := range h.list {
 		str += header.escapedString()
 	}
 	return
 }
 
-func (h *headers) load(mh textproto.MIMEHeader, unescape bool) {
-	for name, values := range mh {
-		for _, value := range values {
-			if unescape {
-				value, _ = url.PathUnescape(value)
-			}
-			h.add(name, value)
-		}
+// Returns a copy of the headers with percent-escaped values decoded.
+func (h *headers) unescaped() headers {
+	list := make([]header, len(h.list))
+	for i, original := range h.list {
+		value, _ := url.PathUnescape(original.value)
+		list[i] = header{original.name, value}
 	}
+	return makeHeaders(list...)
 }
 
 func (h *header) matchName(name string) bool {
-	return strings.ToLower(name) == strings.ToLower(h.name)
+	return strings.EqualFold(name, h.name)
+}
+
+// Lower-cases the ASCII letters in str, without allocating if there are none to change. Header names are ASCII.
+func lowerASCII(str string) string {
+	for i := 0; i < len(str); i++ {
+		if c := str[i]; 'A' <= c && c <= 'Z' {
+			return string(appendLowerASCII(make([]byte, 0, len(str)), str))
+		}
+	}
+	return str
+}
+
+func appendLowerASCII(b []byte, str string) []byte {
+	for i := 0; i < len(str); i++ {
+		c := str[i]
+		if 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		b = append(b, c)
+	}
+	return b
 }
 
 // Returns the header in plain text format, e.g.:
@@ -109,8 +289,65 @@ func (h *header) escapedString() string {
 	return h.name + ": " + h.escapedValue() + "\n"
 }
 
-func loadHeaders(mh textproto.MIMEHeader, unescape bool) headers {
-	h := make(headers, 0, len(mh))
-	h.load(mh, unescape)
-	return h
+// Parse a block of header lines, keeping their order, case, and any duplicates. Names and values share the block's
+// memory. Values are percent-decoded if unescape is true. Lines beginning with whitespace continue the previous value.
+func parseHeaders(block string, unescape bool) (headers, error) {
+	h := make([]header, 0, strings.Count(block, "\n")+1)
+	for len(block) > 0 {
+		var line string
+		line, block = nextLine(block)
+		switch colon := strings.IndexByte(line, ':'); {
+		case line == "":
+		case (line[0] == ' ' || line[0] == '\t') && len(h) > 0:
+			h[len(h)-1].value += " " + strings.TrimSpace(line)
+		case colon > 0:
+			value := strings.TrimLeft(line[colon+1:], " \t")
+			if unescape && strings.IndexByte(value, '%') >= 0 {
+				value, _ = url.PathUnescape(value)
+			}
+			h = append(h, header{line[:colon], value})
+		default:
+			return makeHeaders(h...), errors.New("malformed header line: " + line)
+		}
+	}
+	return makeHeaders(h...), nil
+}
+
+// Find the value of a header in a block of header lines without parsing the rest of the block. Returns an empty
+// string if the header is absent.
+func peekHeader(block, name string, unescape bool) string {
+	for len(block) > 0 {
+		var line string
+		line, block = nextLine(block)
+		if len(line) > len(name) && line[len(name)] == ':' && strings.EqualFold(line[:len(name)], name) {
+			value := strings.TrimLeft(line[len(name)+1:], " \t")
+			if unescape && strings.IndexByte(value, '%') >= 0 {
+				value, _ = url.PathUnescape(value)
+			}
+			return value
+		}
+	}
+	return ""
+}
+
+// Split a serialised packet at the blank line that ends its headers.
+func splitHeaders(packet string) (block, rest string) {
+	for remaining := packet; len(remaining) > 0; {
+		var line string
+		line, remaining = nextLine(remaining)
+		if line == "" {
+			return packet[:len(packet)-len(remaining)], remaining
+		}
+	}
+	return packet, ""
+}
+
+// Returns the first line of str, without its line ending, and the remainder of str after the line ending.
+func nextLine(str string) (line, rest string) {
+	if i := strings.IndexByte(str, '\n'); i >= 0 {
+		line, rest = str[:i], str[i+1:]
+	} else {
+		line = str
+	}
+	return strings.TrimSuffix(line, "\r"), rest
 }
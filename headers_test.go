@@ -3,6 +3,8 @@ package freeswitch
 import (
 	"fmt"
 	"reflect"
+	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -17,21 +19,221 @@ func Equals(t *testing.T, exp interface{}, act interface{}) {
 }
 
 func TestHeaders_Del(t *testing.T) {
-	h := headers{{"foo", "bar"}}
+	h := makeHeaders(header{"foo", "bar"})
 	Equals(t, "bar", h.get("Foo"))
 	h.del("Foo")
 	Equals(t, "", h.get("foo"))
 }
 
 func TestHeaders_Set(t *testing.T) {
-	h := headers{{"val", "old"}}
+	h := makeHeaders(header{"val", "old"})
 	Equals(t, "old", h.get("VAL"))
 	h.set("Val", "new")
 	Equals(t, "new", h.get("VAL"))
 }
 
 func TestHeaders_Add(t *testing.T) {
-	h := headers{{"foo", "bar"}}
+	h := makeHeaders(header{"foo", "bar"})
 	h.add("foo", "baz")
 	Equals(t, []string{"bar", "baz"}, h.getAll("foo"))
 }
+
+func TestParseHeaders(t *testing.T) {
+	h, err := parseHeaders("Content-Type: text/event-plain\r\n"+
+		"content-length:  12\n"+
+		"Reply-Text: %2BOK\n"+
+		"\tcontinued\n"+
+		"\n", true)
+	Equals(t, nil, err)
+	Equals(t, []header{
+		{"Content-Type", "text/event-plain"},
+		{"content-length", "12"},
+		{"Reply-Text", "+OK continued"},
+	}, h.list)
+
+	h, err = parseHeaders("Reply-Text: %2BOK", false)
+	Equals(t, nil, err)
+	Equals(t, []header{{"Reply-Text", "%2BOK"}}, h.list)
+
+	_, err = parseHeaders("not a header\n\n", false)
+	Assert(t, err != nil, "expected an error for a malformed line")
+}
+
+func TestPeekHeader(t *testing.T) {
+	block := "Event-Name: CUSTOM\r\nEvent-Subclass: my%3A%3Aevent\n\n"
+	Equals(t, "CUSTOM", peekHeader(block, "event-name", true))
+	Equals(t, "my::event", peekHeader(block, "Event-Subclass", true))
+	Equals(t, "", peekHeader(block, "Event", true))
+}
+
+func TestHeaders_indexed(t *testing.T) {
+	h := makeHeaders()
+	for i := 0; i < headerIndexThreshold; i++ {
+		h.add(fmt.Sprintf("Header-%d", i), strconv.Itoa(i))
+	}
+	Assert(t, h.index != nil, "expected headers to be indexed")
+	h.add("X-Dup", "a")
+	h.add("x-dup", "b")
+	h.add("Last", "z")
+	Equals(t, "3", h.get("HEADER-3"))
+	Equals(t, []string{"a", "b"}, h.getAll("X-DUP"))
+	Equals(t, "", h.get("Missing"))
+
+	h.set("x-Dup", "c")
+	Equals(t, []string{"c"}, h.getAll("X-Dup"))
+	Equals(t, header{"X-Dup", "c"}, h.list[headerIndexThreshold])
+	Equals(t, "z", h.get("last"))
+
+	h.add("X-Dup", "d")
+	h.del("header-0")
+	Equals(t, "", h.get("Header-0"))
+	Equals(t, []string{"c", "d"}, h.getAll("x-dup"))
+	Equals(t, "z", h.get("Last"))
+
+	for i := 1; h.len() >= headerIndexThreshold; i++ {
+		h.del(fmt.Sprintf("header-%d", i))
+	}
+	Assert(t, h.index == nil, "expected small headers not to be indexed")
+	Equals(t, []string{"c", "d"}, h.getAll("x-dup"))
+	Equals(t, "15", h.get("Header-15"))
+}
+
+func TestHeaders_clone(t *testing.T) {
+	h := makeHeaders(header{"foo", "bar"}, header{"baz", "qux"})
+	c := h.clone()
+	c.del("foo")
+	c.set("baz", "changed")
+	Equals(t, "bar", h.get("foo"))
+	Equals(t, "qux", h.get("baz"))
+}
+
+// The headers of a typical CHANNEL_CREATE event, of which there are about 150.
+func channelCreateHeaders() headers {
+	h := makeHeaders()
+	for _, name := range []string{"Event-Name", "Core-UUID", "FreeSWITCH-Hostname", "FreeSWITCH-Switchname",
+		"FreeSWITCH-IPv4", "FreeSWITCH-IPv6", "Event-Date-Local", "Event-Date-GMT", "Event-Date-Timestamp",
+		"Event-Calling-File", "Event-Calling-Function", "Event-Calling-Line-Number", "Event-Sequence",
+		"Channel-State", "Channel-Call-State", "Channel-State-Number", "Channel-Name", "Unique-ID", "Call-Direction",
+		"Presence-Call-Direction", "Channel-HIT-Dialplan", "Channel-Presence-ID", "Channel-Call-UUID",
+		"Answer-State", "Channel-Read-Codec-Name", "Channel-Read-Codec-Rate", "Channel-Write-Codec-Name",
+		"Channel-Write-Codec-Rate"} {
+		h.add(name, "value of "+name)
+	}
+	for _, name := range []string{"Direction", "Logical-Direction", "Username", "Dialplan", "Caller-ID-Name",
+		"Caller-ID-Number", "Orig-Caller-ID-Name", "Orig-Caller-ID-Number", "Network-Addr", "ANI",
+		"Destination-Number", "Unique-ID", "Source", "Context", "Channel-Name", "Profile-Index",
+		"Profile-Created-Time", "Channel-Created-Time", "Channel-Answered-Time", "Channel-Progress-Time",
+		"Channel-Progress-Media-Time", "Channel-Hangup-Time", "Channel-Transfer-Time", "Channel-Resurrect-Time",
+		"Channel-Bridged-Time", "Channel-Last-Hold", "Channel-Hold-Accum", "Screen-Bit", "Privacy-Hide-Name",
+		"Privacy-Hide-Number"} {
+		h.add("Caller-"+name, "value of "+name)
+	}
+	for i := h.len(); i < 150; i++ {
+		h.add(fmt.Sprintf("variable_sip_var_%d", i), "value")
+	}
+	return h
+}
+
+func benchmarkHeaders(b *testing.B, run func(b *testing.B, h headers)) {
+	b.Run("indexed", func(b *testing.B) {
+		b.ReportAllocs()
+		run(b, channelCreateHeaders())
+	})
+	b.Run("scanned", func(b *testing.B) {
+		h := channelCreateHeaders()
+		h.index, h.next = nil, nil
+		b.ReportAllocs()
+		run(b, h)
+	})
+}
+
+func BenchmarkHeaders_Get(b *testing.B) {
+	benchmarkHeaders(b, func(b *testing.B, h headers) {
+		for i := 0; i < b.N; i++ {
+			h.get("Unique-ID")
+			h.get("Caller-Destination-Number")
+			h.get("variable_sip_var_140")
+			h.get("Missing")
+		}
+	})
+}
+
+func BenchmarkHeaders_Set(b *testing.B) {
+	benchmarkHeaders(b, func(b *testing.B, h headers) {
+		for i := 0; i < b.N; i++ {
+			h.set("Channel-State", "CS_EXECUTE")
+			h.set("variable_sip_var_140", "changed")
+		}
+	})
+}
+
+func BenchmarkHeaders_Del(b *testing.B) {
+	duplicated := channelCreateHeaders()
+	duplicated.add("Unique-ID", "value of a duplicate")
+	for _, bench := range []struct {
+		name, header string
+		h            headers
+	}{
+		{"front", "Event-Name", channelCreateHeaders()}, // as by Client.sendEvent()
+		{"duplicated", "Unique-ID", duplicated},
+		{"end", "variable_sip_var_149", channelCreateHeaders()},
+	} {
+		b.Run(bench.name+"/indexed", func(b *testing.B) {
+			benchmarkDel(b, bench.h, bench.header)
+		})
+		b.Run(bench.name+"/scanned", func(b *testing.B) {
+			h := bench.h.clone()
+			h.index, h.next = nil, nil
+			benchmarkDel(b, h, bench.header)
+		})
+	}
+}
+
+// Deleting changes the headers, so each deletion is from a fresh copy, made in batches outside the timer.
+func benchmarkDel(b *testing.B, h headers, name string) {
+	const batch = 1000
+	copies := make([]headers, batch)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i += batch {
+		b.StopTimer()
+		for j := range copies {
+			if copies[j] = h.clone(); h.index == nil {
+				copies[j].index, copies[j].next = nil, nil
+			}
+		}
+		b.StartTimer()
+		for j := 0; j < batch && i+j < b.N; j++ {
+			copies[j].del(name)
+		}
+	}
+}
+
+func TestHeaders_indexConsistency(t *testing.T) {
+	h := makeHeaders()
+	check := func(op string) {
+		for _, header := range h.list {
+			var scanned []string
+			for i := h.scan(header.name, 0); i >= 0; i = h.scan(header.name, i+1) {
+				scanned = append(scanned, h.list[i].value)
+			}
+			if got := h.getAll(header.name); !reflect.DeepEqual(scanned, got) {
+				t.Fatalf("after %s, %s: expected %v, got %v", op, header.name, scanned, got)
+			}
+		}
+	}
+	for i := 0; i < 200; i++ {
+		name := fmt.Sprintf("Name-%d", i*7%23)
+		switch i % 5 {
+		case 0, 1:
+			h.add(name, strconv.Itoa(i))
+		case 2:
+			h.add(strings.ToUpper(name), strconv.Itoa(i))
+		case 3:
+			h.set(name, strconv.Itoa(i))
+		case 4:
+			h.del(fmt.Sprintf("name-%d", i*3%23))
+		}
+		check(fmt.Sprintf("operation %d", i))
+	}
+	Assert(t, h.index != nil, "expected headers to be indexed")
+}
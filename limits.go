@@ -0,0 +1,267 @@
+package freeswitch
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Limits restrict how quickly commands of one kind are sent to FreeSWITCH, and how many may be outstanding at once.
+// Commands that would exceed a limit wait their turn, in the order they were made, until the client's Timeout
+// passes, when they fail with ETimeout. See Client.APILimits and Client.BgAPILimits.
+type Limits struct {
+	// The average number of commands that may be sent per second. Zero means no limit.
+	Rate float64
+
+	// The number of commands that may be sent at once before Rate applies (default 1).
+	Burst int
+
+	// The number of commands that may be outstanding at once. Zero means no limit. An api command is outstanding
+	// until its response arrives, and a bgapi command until its job completes or is abandoned. If a job's
+	// BACKGROUND_JOB event is lost, e.g. because of a filter or an unsubscription, the job holds its place until its
+	// context is done or the client disconnects, so use QueryContext() with a deadline to bound how long that can be.
+	MaxInFlight int
+}
+
+// LimitStats describe the effect of a client's Limits on one kind of command. See Client.APILimitStats().
+type LimitStats struct {
+	// The number of commands that have been allowed to proceed, whether or not they waited.
+	Admitted uint64
+
+	// The number of those commands that had to wait.
+	Delayed uint64
+
+	// The number of commands that gave up waiting, due to the client's Timeout or their contexts.
+	Rejected uint64
+
+	// The total and longest time spent waiting by admitted commands.
+	TotalWait time.Duration
+	MaxWait   time.Duration
+
+	// The number of commands waiting, and outstanding, at the time of the call.
+	Waiting  int
+	InFlight int
+}
+
+// Admits commands according to Limits.
+type limiter struct {
+	turn chan struct{} // held by the command at the front of the queue, so that commands are admitted in order
+	lock sync.Mutex
+
+	// The following are guarded by lock.
+	tokens   float64
+	refilled time.Time
+	released chan struct{} // closed, and replaced, when an outstanding command finishes
+	stats    LimitStats
+}
+
+func newLimiter() *limiter {
+	return &limiter{
+		turn:     make(chan struct{}, 1),
+		released: make(chan struct{}),
+	}
+}
+
+// APILimitStats reports the effect of APILimits on commands run with Execute() and its siblings.
+func (c *Client) APILimitStats() LimitStats {
+	return c.apiLimiter.snapshot()
+}
+
+// BgAPILimitStats reports the effect of BgAPILimits on commands run with Query() and its siblings.
+func (c *Client) BgAPILimitStats() LimitStats {
+	return c.bgAPILimiter.snapshot()
+}
+
+func (l *limiter) snapshot() (stats LimitStats) {
+	exclusive(&l.lock, func() { stats = l.stats })
+	return
+}
+
+// Wait until the given limits allow a command to proceed, the timeout passes, or the context is done. On success,
+// the returned function must be called when the command is no longer outstanding. It may be called more than once.
+func (l *limiter) acquire(ctx context.Context, limits Limits, timeout <-chan time.Time) (func(), error) {
+	if release, ok := l.tryAcquire(limits); ok {
+		return release, nil
+	}
+	start := time.Now()
+	exclusive(&l.lock, func() { l.stats.Waiting++ })
+	defer exclusive(&l.lock, func() { l.stats.Waiting-- })
+
+	// Wait for a turn at the front of the queue
+	select {
+	case l.turn <- struct{}{}:
+	case <-timeout:
+		return nil, l.reject(ETimeout)
+	case <-ctx.Done():
+		return nil, l.reject(ctx.Err())
+	}
+	defer func() { <-l.turn }()
+
+	for {
+		var (
+			ok       bool
+			delay    time.Duration
+			released chan struct{}
+		)
+		exclusive(&l.lock, func() {
+			if ok, delay = l.take(limits, time.Now()); ok {
+				l.admit(time.Since(start))
+			}
+			released = l.released
+		})
+		if ok {
+			return l.releaser(), nil
+		}
+		// Wait for a token, if one is needed, or for an outstanding command to finish
+		var (
+			timer *time.Timer
+			tick  <-chan time.Time
+			err   error
+		)
+		if delay > 0 {
+			timer = time.NewTimer(delay)
+			tick = timer.C
+		}
+		select {
+		case <-released:
+		case <-tick:
+		case <-timeout:
+			err = ETimeout
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if err != nil {
+			return nil, l.reject(err)
+		}
+	}
+}
+
+// Admit a command if the given limits allow it to proceed immediately, and no other is waiting. See acquire().
+func (l *limiter) tryAcquire(limits Limits) (release func(), ok bool) {
+	if limits.Rate <= 0 && limits.MaxInFlight <= 0 {
+		exclusive(&l.lock, func() { l.admit(0) })
+		return l.releaser(), true
+	}
+	select {
+	case l.turn <- struct{}{}:
+		defer func() { <-l.turn }()
+	default:
+		return nil, false
+	}
+	exclusive(&l.lock, func() {
+		if ok, _ = l.take(limits, time.Now()); ok {
+			l.admit(0)
+		}
+	})
+	if ok {
+		release = l.releaser()
+	}
+	return
+}
+
+// Try to take a token and an in-flight slot. If either is unavailable, returns false, and how long until a token
+// will be available, or zero if waiting for a slot.
+func (l *limiter) take(limits Limits, now time.Time) (ok bool, delay time.Duration) {
+	if limits.MaxInFlight > 0 && l.stats.InFlight >= limits.MaxInFlight {
+		return false, 0
+	}
+	if limits.Rate > 0 {
+		burst := float64(limits.Burst)
+		if burst < 1 {
+			burst = 1
+		}
+		if l.refilled.IsZero() {
+			l.tokens = burst
+		} else if l.tokens += now.Sub(l.refilled).Seconds() * limits.Rate; l.tokens > burst {
+			l.tokens = burst
+		}
+		l.refilled = now
+		if l.tokens < 1 {
+			return false, time.Duration((1 - l.tokens) / limits.Rate * float64(time.Second))
+		}
+		l.tokens--
+	}
+	return true, 0
+}
+
+// Record the admission of a command. Call with lock held.
+func (l *limiter) admit(wait time.Duration) {
+	l.stats.Admitted++
+	l.stats.InFlight++
+	if wait > 0 {
+		l.stats.Delayed++
+		l.stats.TotalWait += wait
+		if wait > l.stats.MaxWait {
+			l.stats.MaxWait = wait
+		}
+	}
+}
+
+func (l *limiter) reject(err error) error {
+	exclusive(&l.lock, func() { l.stats.Rejected++ })
+	return err
+}
+
+// Returns a function that frees a command's place, once.
+func (l *limiter) releaser() func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			exclusive(&l.lock, func() {
+				l.stats.InFlight--
+				close(l.released)
+				l.released = make(chan struct{})
+			})
+		})
+	}
+}
+
+// Pass commands to the connection once the given limits allow each to proceed, all within a single Timeout. Commands
+// that can proceed immediately are sent together; when one must wait, those before it are sent first. The admit
+// function is called for each command allowed to proceed, with the function that frees its place. Returns an error
+// for each command that wasn't sent. Once one command fails, the rest fail with the same error.
+func (c *Client) enqueueLimited(ctx context.Context, l *limiter, limits Limits, cmds []*command,
+	admit func(i int, release func())) []error {
+	var (
+		errs     = make([]error, len(cmds))
+		releases = make([]func(), len(cmds))
+		from     int // the first command not yet sent
+		err      error
+		timeout  = time.NewTimer(c.Timeout)
+	)
+	defer timeout.Stop()
+	send := func(to int) {
+		if from < to {
+			if err = c.enqueue(ctx, timeout.C, cmds[from:to]...); err != nil {
+				for i := from; i < to; i++ {
+					errs[i] = err
+					releases[i]()
+				}
+			}
+			from = to
+		}
+	}
+	for i := 0; i < len(cmds) && err == nil; i++ {
+		release, ok := l.tryAcquire(limits)
+		if !ok {
+			send(i)
+			if err == nil {
+				release, err = l.acquire(ctx, limits, timeout.C)
+			}
+		}
+		if err == nil {
+			releases[i] = release
+			admit(i, release)
+		}
+	}
+	if err == nil {
+		send(len(cmds))
+	}
+	for i := from; i < len(cmds); i++ {
+		errs[i] = err
+	}
+	return errs
+}
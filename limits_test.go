@@ -0,0 +1,59 @@
+package freeswitch
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLimiter_rate(t *testing.T) {
+	l := newLimiter()
+	limits := Limits{Rate: 50, Burst: 2}
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		if _, err := l.acquire(context.Background(), limits, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("expected two commands to wait for tokens, but all took %s", elapsed)
+	}
+	stats := l.snapshot()
+	Equals(t, uint64(4), stats.Admitted)
+	Equals(t, uint64(2), stats.Delayed)
+	Equals(t, 4, stats.InFlight)
+	Assert(t, stats.MaxWait > 0 && stats.TotalWait >= stats.MaxWait, "expected wait times to be recorded")
+}
+
+func TestLimiter_maxInFlight(t *testing.T) {
+	l := newLimiter()
+	limits := Limits{MaxInFlight: 1}
+	release, err := l.acquire(context.Background(), limits, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = l.acquire(context.Background(), limits, time.After(10*time.Millisecond))
+	Equals(t, ETimeout, err)
+
+	admitted := make(chan func())
+	go func() {
+		release, _ := l.acquire(context.Background(), limits, nil)
+		admitted <- release
+	}()
+	select {
+	case <-admitted:
+		t.Fatal("expected command to wait")
+	case <-time.After(10 * time.Millisecond):
+	}
+	release()
+	release() // no effect
+	(<-admitted)()
+
+	stats := l.snapshot()
+	Equals(t, uint64(2), stats.Admitted)
+	Equals(t, uint64(1), stats.Delayed)
+	Equals(t, uint64(1), stats.Rejected)
+	Equals(t, 0, stats.InFlight)
+	Equals(t, 0, stats.Waiting)
+}
@@ -1,5 +1,10 @@
 package freeswitch
 
+import (
+	"sync"
+	"sync/atomic"
+)
+
 type packetType string
 
 const (
@@ -8,12 +13,15 @@ const (
 	ptDisconnectNotice packetType = "text/disconnect-notice"
 	ptResult           packetType = "api/response"
 	ptEventPlain       packetType = "text/event-plain"
-	ptEventJSON        packetType = "text/event-json" // Unused
+	ptEventJSON        packetType = "text/event-json"
+	ptEventXML         packetType = "text/event-xml"
 )
 
 type rawPacket struct {
 	headers headers
 	body    string
+	decode  sync.Once   // guards decoding of the body by the event that wraps it
+	decoded atomic.Bool // set once the body has been decoded
 }
 
 type packet interface {
@@ -34,7 +42,11 @@ func (rp *rawPacket) cast() packet {
 	case ptCommandReply:
 		return &reply{rp}
 	case ptEventPlain:
-		return &Event{rawPacket: rp}
+		return &Event{rawPacket: rp, format: EventFormatPlain}
+	case ptEventJSON:
+		return &Event{rawPacket: rp, format: EventFormatJSON}
+	case ptEventXML:
+		return &Event{rawPacket: rp, format: EventFormatXML}
 	case ptResult:
 		return &result{rp}
 	case ptDisconnectNotice:
@@ -0,0 +1,101 @@
+package freeswitch
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"sync"
+)
+
+// Bodies larger than this are read into buffers that aren't returned to the pool, so that one large packet doesn't
+// pin a large buffer for the life of the process.
+const maxPooledBodySize = 64 << 10
+
+var bodyBuffers = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, 0, 4096)
+		return &b
+	},
+}
+
+// Reads ESL packets from a connection. Each packet's header block is copied once from the underlying buffer, and its
+// headers are sliced from that copy rather than allocated individually. Bodies are read through pooled buffers.
+type packetReader struct {
+	reader *bufio.Reader
+	block  []byte // reused between packets to accumulate header lines
+}
+
+func newPacketReader(r io.Reader) *packetReader {
+	return &packetReader{reader: bufio.NewReaderSize(r, 16<<10)}
+}
+
+// Read the next packet. Header values are not unescaped; that's left to whichever packet type needs it.
+func (r *packetReader) next() (*rawPacket, error) {
+	block, err := r.readBlock()
+	if err != nil {
+		return nil, err
+	}
+	h, err := parseHeaders(block, false)
+	if err != nil {
+		return nil, err
+	}
+	p := &rawPacket{headers: h}
+	if contentLength := h.get("Content-Length"); contentLength != "" {
+		n, err := strconv.Atoi(contentLength)
+		if err != nil {
+			return nil, err
+		}
+		if n > 0 {
+			if p.body, err = r.readBody(n); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return p, nil
+}
+
+// Read header lines up to and including the blank line that ends them, and return them as a single string. Blank lines
+// before the first header are skipped.
+func (r *packetReader) readBlock() (string, error) {
+	r.block = r.block[:0]
+	lineStart := 0
+	for {
+		line, err := r.reader.ReadSlice('\n')
+		r.block = append(r.block, line...)
+		switch {
+		case err == bufio.ErrBufferFull:
+			continue // the rest of the line follows
+		case err != nil:
+			return string(r.block), err
+		}
+		if isBlankLine(r.block[lineStart:]) {
+			if lineStart > 0 {
+				return string(r.block[:lineStart]), nil
+			}
+			r.block = r.block[:0]
+		}
+		lineStart = len(r.block)
+	}
+}
+
+// Read a body of n bytes.
+func (r *packetReader) readBody(n int) (string, error) {
+	buf := bodyBuffers.Get().(*[]byte)
+	if cap(*buf) < n {
+		*buf = make([]byte, n)
+	}
+	body := (*buf)[:n]
+	_, err := io.ReadFull(r.reader, body)
+	var str string
+	if err == nil {
+		str = string(body)
+	}
+	if cap(*buf) <= maxPooledBodySize {
+		bodyBuffers.Put(buf)
+	}
+	return str, err
+}
+
+func isBlankLine(line []byte) bool {
+	return len(line) == 1 || (len(line) == 2 && line[0] == '\r')
+}
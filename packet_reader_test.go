@@ -0,0 +1,163 @@
+package freeswitch
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestPacketReader(t *testing.T) {
+	r := newPacketReader(strings.NewReader("\n" +
+		"Content-Type: command/reply\r\n" +
+		"Reply-Text: +OK accepted\r\n" +
+		"\r\n" +
+		"Content-Type: api/response\n" +
+		"Content-Length: 5\n" +
+		"\n" +
+		"+OK\n\n" +
+		"Content-Type: api/response\n" +
+		"Content-Length: 5\n" +
+		"\n" +
+		"+O"))
+
+	p, err := r.next()
+	Equals(t, nil, err)
+	Equals(t, []header{{"Content-Type", "command/reply"}, {"Reply-Text", "+OK accepted"}}, p.headers.list)
+	Equals(t, "", p.body)
+
+	p, err = r.next()
+	Equals(t, nil, err)
+	Equals(t, ptResult, p.packetType())
+	Equals(t, "+OK\n\n", p.body)
+
+	_, err = r.next()
+	Equals(t, io.ErrUnexpectedEOF, err)
+
+	_, err = r.next()
+	Equals(t, io.EOF, err)
+}
+
+func TestPacketReader_longLine(t *testing.T) {
+	value := strings.Repeat("x", 40<<10)
+	p, err := newPacketReader(strings.NewReader("Content-Type: command/reply\nReply-Text: " + value + "\n\n")).next()
+	Equals(t, nil, err)
+	Equals(t, value, p.headers.get("Reply-Text"))
+}
+
+func TestEvent_Name_lazy(t *testing.T) {
+	c := newClient()
+	e := c.LoadEvent("Event-Name: CUSTOM\nEvent-Subclass: my%3A%3Aevent\n\n")
+	Equals(t, EventName{"CUSTOM", "my::event"}, *e.Name())
+	Assert(t, e.headers.len() == 0, "expected headers not to be decoded")
+	Equals(t, "my::event", e.Get("Event-Subclass"))
+	Equals(t, EventName{"CUSTOM", "my::event"}, *e.Name())
+}
+
+// A CHANNEL_CREATE event of typical size, as it arrives on the wire.
+var benchmarkPacket = func() []byte {
+	var body strings.Builder
+	body.WriteString("Event-Name: CHANNEL_CREATE\n")
+	body.WriteString("Core-UUID: 8b2c5e3a-0d8e-4a0c-b2b5-3f0f0b1a6c2d\n")
+	body.WriteString("Event-Date-Local: 2024-01-01%2012%3A00%3A00\n")
+	body.WriteString("Unique-ID: 0b8d6c0e-6f7e-4d6b-9d5c-6c4b1a2f3e4d\n")
+	for i := 0; i < 60; i++ {
+		fmt.Fprintf(&body, "variable_header_%d: value%%20number%%20%d\n", i, i)
+	}
+	return []byte("Content-Length: " + strconv.Itoa(body.Len()) + "\n" +
+		"Content-Type: text/event-plain\n\n" + body.String())
+}()
+
+// Read a packet as the client did before packetReader, with net/textproto, for comparison.
+func readPacketTextproto(r *textproto.Reader) (*rawPacket, error) {
+	mime, err := r.ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	h := headers{}
+	for name, values := range mime {
+		for _, value := range values {
+			h.add(name, value)
+		}
+	}
+	p := &rawPacket{headers: h}
+	if n, _ := strconv.Atoi(h.get("Content-Length")); n > 0 {
+		body := make([]byte, n)
+		if _, err = io.ReadFull(r.R, body); err != nil {
+			return nil, err
+		}
+		p.body = string(body)
+	}
+	return p, nil
+}
+
+func decodeTextproto(e *Event) {
+	mime, _ := textproto.NewReader(bufio.NewReader(strings.NewReader(e.rawPacket.body))).ReadMIMEHeader()
+	h := headers{}
+	for name, values := range mime {
+		for _, value := range values {
+			h.add(name, value)
+		}
+	}
+	e.headers = h.unescaped()
+}
+
+func benchmarkPackets(b *testing.B, read func(io.Reader) func() (*rawPacket, error)) {
+	const batch = 100
+	stream := bytes.Repeat(benchmarkPacket, batch)
+	b.SetBytes(int64(len(benchmarkPacket)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i += batch {
+		next := read(bytes.NewReader(stream))
+		for j := 0; j < batch && i+j < b.N; j++ {
+			if _, err := next(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkPacketReader(b *testing.B) {
+	benchmarkPackets(b, func(r io.Reader) func() (*rawPacket, error) {
+		return newPacketReader(r).next
+	})
+}
+
+func BenchmarkPacketReader_textproto(b *testing.B) {
+	benchmarkPackets(b, func(r io.Reader) func() (*rawPacket, error) {
+		tp := textproto.NewReader(bufio.NewReader(r))
+		return func() (*rawPacket, error) { return readPacketTextproto(tp) }
+	})
+}
+
+func benchmarkEvents(b *testing.B, use func(e *Event)) {
+	p, err := newPacketReader(bytes.NewReader(benchmarkPacket)).next()
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		use((&rawPacket{headers: p.headers, body: p.body}).cast().(*Event))
+	}
+}
+
+func BenchmarkEvent_Name(b *testing.B) {
+	benchmarkEvents(b, func(e *Event) { e.Name() })
+}
+
+func BenchmarkEvent_Get(b *testing.B) {
+	benchmarkEvents(b, func(e *Event) { e.Get("Unique-ID") })
+}
+
+func BenchmarkEvent_Get_textproto(b *testing.B) {
+	benchmarkEvents(b, func(e *Event) {
+		decodeTextproto(e)
+		e.Get("Unique-ID")
+	})
+}
@@ -0,0 +1,152 @@
+package freeswitch
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+const defaultServerAddr = ":8084"
+
+// SessionHandler is a function that controls a call connected to a Server. See Server.
+type SessionHandler func(*Session)
+
+// Server accepts outbound event socket connections, which FreeSWITCH makes when a call reaches the "socket" dialplan
+// application, e.g.:
+//
+//	<action application="socket" data="127.0.0.1:8084 async full"/>
+//
+// Each connection is given its own Session, which is passed to the server's handler once the "connect" handshake
+// has completed. When the handler returns, the session is closed. A zero Server is not valid; use NewServer().
+type Server struct {
+	// The address on which ListenAndServe() should listen (default ":8084").
+	Addr string
+
+	// Timeout to use for each session's handshake, and also for its commands to be accepted (default 5 seconds).
+	Timeout time.Duration
+
+	// The format in which FreeSWITCH should send events to each session (default EventFormatPlain).
+	EventFormat EventFormat
+
+	// How each session passes events to its handlers. See DispatchOptions.
+	Dispatch DispatchOptions
+
+	// Optional. Called when an event handler of any session panics. See Client.OnHandlerPanic.
+	OnHandlerPanic func(e *Event, recovered interface{}, stack []byte)
+
+	// Optional. Called when sending and receiving data to/from FreeSWITCH, on any session.
+	Logger func(packet string, isOutbound bool)
+
+	handler  SessionHandler
+	listener net.Listener
+	sessions map[*Session]struct{}
+	lock     sync.Mutex
+	closed   bool
+}
+
+// NewServer makes a new server with the default address and timeout, which will pass sessions to the given handler.
+func NewServer(handler SessionHandler) *Server {
+	return &Server{
+		Addr:    defaultServerAddr,
+		Timeout: defaultTimeout,
+
+		handler:  handler,
+		sessions: map[*Session]struct{}{},
+	}
+}
+
+// ListenAndServe listens on the TCP address Addr, and then calls Serve(). It blocks until the server is closed.
+func (s *Server) ListenAndServe() error {
+	addr := s.Addr
+	if addr == "" {
+		addr = defaultServerAddr
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(listener)
+}
+
+// Serve accepts connections from FreeSWITCH on the given listener, and blocks until the listener fails or the server
+// is closed. Closing the server will make Serve() return with no error.
+func (s *Server) Serve(listener net.Listener) error {
+	var closed bool
+	exclusive(&s.lock, func() {
+		if closed = s.closed; !closed {
+			s.listener = listener
+		}
+	})
+	if closed {
+		listener.Close()
+		return nil
+	}
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			exclusive(&s.lock, func() { closed = s.closed })
+			if closed {
+				err = nil
+			}
+			return err
+		}
+		go s.serveSession(conn)
+	}
+}
+
+// Close stops the server from accepting new connections, and closes any sessions in progress.
+func (s *Server) Close() {
+	var sessions []*Session
+	exclusive(&s.lock, func() {
+		s.closed = true
+		if s.listener != nil {
+			s.listener.Close()
+		}
+		for session := range s.sessions {
+			sessions = append(sessions, session)
+		}
+	})
+	for _, session := range sessions {
+		session.Close()
+	}
+}
+
+func (s *Server) serveSession(conn net.Conn) {
+	c := newClient()
+	c.Timeout = s.Timeout
+	c.Logger = s.Logger
+	c.EventFormat = s.EventFormat
+	c.Dispatch = s.Dispatch
+	c.OnHandlerPanic = s.OnHandlerPanic
+
+	session := &Session{
+		client: c,
+		done:   make(chan struct{}),
+	}
+	c.ended = session.done
+
+	var closed bool
+	exclusive(&s.lock, func() {
+		if closed = s.closed; !closed {
+			s.sessions[session] = struct{}{}
+		}
+	})
+	if closed {
+		conn.Close()
+		return
+	}
+
+	c.control.Lock()
+	c.setRunning(true)
+	session.err = c.serve(context.Background(), conn, session.greet, func() {
+		go func() {
+			s.handler(session)
+			session.Close()
+		}()
+	})
+	c.control.Unlock()
+
+	exclusive(&s.lock, func() { delete(s.sessions, session) })
+	close(session.done)
+}
@@ -0,0 +1,149 @@
+package freeswitch
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Plays the part of FreeSWITCH in an outbound session: dials the server, and answers commands in order.
+type dialer struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func dialServer(t *testing.T, addr string) *dialer {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &dialer{t, conn, bufio.NewReader(conn)}
+}
+
+// Read the next command sent by the server, up to and excluding its terminating blank line.
+func (d *dialer) expect() string {
+	var lines []string
+	for {
+		line, err := d.reader.ReadString('\n')
+		if err != nil {
+			d.t.Fatal(err)
+		}
+		if line == "\n" {
+			return strings.Join(lines, "\n")
+		}
+		lines = append(lines, strings.TrimSuffix(line, "\n"))
+	}
+}
+
+func (d *dialer) reply(headers ...string) {
+	d.conn.Write([]byte(strings.Join(headers, "\n") + "\n\n"))
+}
+
+func TestServer_Session(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		sessions = make(chan *Session)
+		appErrs  = make(chan error)
+		server   = NewServer(func(s *Session) {
+			sessions <- s
+			appErrs <- s.ExecuteApp("playback", "/tmp/hello.wav")
+			appErrs <- s.MyEvents()
+		})
+		served = make(chan error)
+	)
+	server.Timeout = time.Minute
+	go func() { served <- server.Serve(listener) }()
+
+	fs := dialServer(t, listener.Addr().String())
+	defer fs.conn.Close()
+
+	Equals(t, "connect", fs.expect())
+	fs.reply(
+		"Event-Name: CHANNEL_DATA",
+		"Unique-ID: 0d5b5c2a-8f4c-4d8c-9a6e-2b7e3c1f4a10",
+		"Caller-Caller-ID-Name: Outbound%20Call",
+		"Content-Type: command/reply",
+		"Reply-Text: %2BOK%0A",
+	)
+	Equals(t, "events plain BACKGROUND_JOB", fs.expect())
+	fs.reply("Content-Type: command/reply", "Reply-Text: +OK event listener enabled plain")
+
+	session := <-sessions
+	Equals(t, "0d5b5c2a-8f4c-4d8c-9a6e-2b7e3c1f4a10", session.UUID())
+	Equals(t, "Outbound Call", session.Channel().Get("Caller-Caller-ID-Name"))
+	Equals(t, "CHANNEL_DATA", session.Channel().Name().Name)
+
+	Equals(t, strings.Join([]string{
+		"sendmsg",
+		"call-command: execute",
+		"execute-app-name: playback",
+		"execute-app-arg: /tmp/hello.wav",
+	}, "\n"), fs.expect())
+	fs.reply("Content-Type: command/reply", "Reply-Text: +OK")
+	Equals(t, nil, <-appErrs)
+
+	Equals(t, "myevents plain", fs.expect())
+	fs.reply("Content-Type: command/reply", "Reply-Text: +OK Events Enabled")
+	Equals(t, nil, <-appErrs)
+
+	// Returning from the handler closes the session, after which commands fail without waiting for the timeout.
+	Equals(t, nil, session.Wait())
+	_, err = session.Execute("status")
+	Equals(t, ENotConnected, err)
+
+	server.Close()
+	Equals(t, nil, <-served)
+}
+
+func TestServer_ConnectFailure(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewServer(func(s *Session) { t.Error("handler should not be called") })
+	go server.Serve(listener)
+	defer server.Close()
+
+	fs := dialServer(t, listener.Addr().String())
+	defer fs.conn.Close()
+
+	Equals(t, "connect", fs.expect())
+	fs.reply("Content-Type: command/reply", "Reply-Text: -ERR no such channel")
+
+	// The server hangs up on a failed handshake.
+	_, err = fs.reader.ReadByte()
+	Assert(t, err != nil, "expected connection to be closed")
+}
+
+func TestServer_Close_handshake(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewServer(func(s *Session) {})
+	server.Timeout = time.Minute
+	go server.Serve(listener)
+
+	fs := dialServer(t, listener.Addr().String())
+	defer fs.conn.Close()
+	Equals(t, "connect", fs.expect())
+
+	// Closing the server mustn't wait for a session's handshake to finish.
+	closed := make(chan struct{})
+	go func() {
+		server.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("expected Close() not to wait for the handshake")
+	}
+}
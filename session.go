@@ -0,0 +1,135 @@
+package freeswitch
+
+import (
+	"context"
+	"strings"
+)
+
+// Session represents a single outbound event socket connection, made by FreeSWITCH to a Server to control one
+// channel. Sessions are created by a Server and passed to its handler. Once a session has ended, its commands fail
+// with ENotConnected.
+type Session struct {
+	client  *Client
+	channel *Event
+	done    chan struct{}
+	err     error
+}
+
+// Channel returns the channel data sent by FreeSWITCH in reply to the "connect" command, as a CHANNEL_DATA event.
+func (s *Session) Channel() *Event {
+	return s.channel
+}
+
+// UUID returns the unique ID of the session's channel.
+func (s *Session) UUID() string {
+	return s.channel.Get("Unique-ID")
+}
+
+// Execute runs an API command, and returns the response as a string. See Client.Execute().
+func (s *Session) Execute(app string, args ...string) (string, error) {
+	return s.client.Execute(app, args...)
+}
+
+// ExecuteContext runs an API command, giving up when the given context is done. See Client.ExecuteContext().
+func (s *Session) ExecuteContext(ctx context.Context, app string, args ...string) (string, error) {
+	return s.client.ExecuteContext(ctx, app, args...)
+}
+
+// Query runs an API command, and returns a channel through which its result will be passed. See Client.Query().
+func (s *Session) Query(app string, args ...string) (chan string, error) {
+	return s.client.Query(app, args...)
+}
+
+// QueryContext runs an API command in the background, giving up when the given context is done. See
+// Client.QueryContext().
+func (s *Session) QueryContext(ctx context.Context, app string, args ...string) (chan string, error) {
+	return s.client.QueryContext(ctx, app, args...)
+}
+
+// Handle the given event with the given handler. See Client.On().
+func (s *Session) On(eventName string, handler EventHandler) (cancel func()) {
+	return s.client.On(eventName, handler)
+}
+
+// Handle custom events. See Client.On().
+func (s *Session) OnCustom(eventSubclass string, handler EventHandler) (cancel func()) {
+	return s.client.OnCustom(eventSubclass, handler)
+}
+
+// Handle every event. See Client.OnAll().
+func (s *Session) OnAll(handler EventHandler) (cancel func()) {
+	return s.client.OnAll(handler)
+}
+
+// Handle several events with one handler. See Client.OnMany().
+func (s *Session) OnMany(handler EventHandler, eventNames ...string) (cancel func()) {
+	return s.client.OnMany(handler, eventNames...)
+}
+
+// Subscribe returns a channel through which events will be received. See Client.Subscribe().
+func (s *Session) Subscribe(eventNames ...string) (events <-chan *Event, cancel func()) {
+	return s.client.Subscribe(eventNames...)
+}
+
+// ExecuteApp runs a dialplan application (e.g. "answer", "playback") on the session's channel. It returns once
+// FreeSWITCH has accepted the application, which may be before the application has finished.
+func (s *Session) ExecuteApp(app string, args ...string) error {
+	return s.sendMsg("execute",
+		"execute-app-name: "+app,
+		"execute-app-arg: "+strings.Join(args, " "),
+	)
+}
+
+// Hangup hangs up the session's channel with the given cause, e.g. "NORMAL_CLEARING".
+func (s *Session) Hangup(cause string) error {
+	return s.sendMsg("hangup", "hangup-cause: "+cause)
+}
+
+// MyEvents subscribes the session to all events for its own channel, in the server's EventFormat.
+func (s *Session) MyEvents() error {
+	_, err := s.client.sendCommand(myEventsCommand(s.client.EventFormat, "")...)
+	return err
+}
+
+// Linger asks FreeSWITCH to keep the session open after its channel hangs up, so that remaining events can be
+// received. Call Close() when done.
+func (s *Session) Linger() error {
+	_, err := s.client.sendCommand("linger")
+	return err
+}
+
+// Close the session's connection.
+func (s *Session) Close() {
+	s.client.Shutdown()
+}
+
+// Wait blocks until the session's connection is closed, and returns the reason it was closed. The error will be nil
+// if Close() was called.
+func (s *Session) Wait() error {
+	<-s.done
+	return s.err
+}
+
+// Send the "connect" command that begins an outbound session, and capture the channel data that FreeSWITCH sends
+// in reply.
+func (s *Session) greet(h *handshake) {
+	h.send("connect")
+	h.next(func(response *rawPacket) {
+		data := &rawPacket{headers: response.headers.unescaped()}
+		if result, ok := data.cast().(*reply); ok && result.ok() {
+			s.channel = &Event{
+				rawPacket: data,
+				client:    s.client,
+				headers:   data.headers.clone(),
+			}
+		} else {
+			h.err = EUnexpectedResponse
+		}
+	})
+}
+
+func (s *Session) sendMsg(callCommand string, lines ...string) error {
+	msg := append([]string{"sendmsg", "call-command: " + callCommand}, lines...)
+	_, err := s.client.sendCommand(strings.Join(msg, "\n"))
+	return err
+}
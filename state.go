@@ -0,0 +1,77 @@
+package freeswitch
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// ConnectionState describes the progress of a client's connection to FreeSWITCH. See Client.OnStateChange.
+type ConnectionState int32
+
+const (
+	// The client is not connected. This is the initial state.
+	StateDisconnected ConnectionState = iota
+
+	// The client is opening a connection to FreeSWITCH.
+	StateConnecting
+
+	// The client has connected, and FreeSWITCH has accepted its password.
+	StateAuthenticated
+
+	// The client has subscribed to events for its registered handlers, and is ready to send commands.
+	StateSubscribed
+)
+
+// The name of the state, e.g. "connecting".
+func (s ConnectionState) String() string {
+	switch s {
+	case StateDisconnected:
+		return "disconnected"
+	case StateConnecting:
+		return "connecting"
+	case StateAuthenticated:
+		return "authenticated"
+	case StateSubscribed:
+		return "subscribed"
+	}
+	return "unknown"
+}
+
+// State returns the current state of the client's connection.
+func (c *Client) State() ConnectionState {
+	return ConnectionState(atomic.LoadInt32(&c.state))
+}
+
+// Ready returns a channel that is closed once the client has connected, authenticated, and subscribed to events for
+// its registered handlers. When the client disconnects, a new channel is made, so call Ready() again after
+// disconnection to wait for reconnection.
+func (c *Client) Ready() <-chan struct{} {
+	c.readyLock.Lock()
+	defer c.readyLock.Unlock()
+	return c.ready
+}
+
+// WaitReady blocks until the client is ready (see Ready()), or until the given context is done, in which case the
+// context's error is returned.
+func (c *Client) WaitReady(ctx context.Context) error {
+	select {
+	case <-c.Ready():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *Client) setState(state ConnectionState, err error) {
+	old := ConnectionState(atomic.SwapInt32(&c.state, int32(state)))
+	switch {
+	case state == StateSubscribed:
+		atomic.AddUint32(&c.connected, 1)
+		exclusive(&c.readyLock, func() { close(c.ready) })
+	case state == StateDisconnected && old == StateSubscribed:
+		exclusive(&c.readyLock, func() { c.ready = make(chan struct{}) })
+	}
+	if callback := c.OnStateChange; callback != nil {
+		callback(state, err)
+	}
+}
@@ -0,0 +1,73 @@
+package freeswitch
+
+import "sync"
+
+// SubscribeOptions configure a channel returned by SubscribeWith().
+type SubscribeOptions struct {
+	// The capacity of the channel (default 1024). Use a negative number for an unbuffered channel.
+	Buffer int
+
+	// What to do when the channel is full (default OverflowBlock). Blocking holds up the handler goroutine, or with
+	// queued dispatch, the queue. Dropped events are counted by DroppedEvents().
+	Overflow OverflowPolicy
+}
+
+// A channel of events, fed by a handler.
+type subscription struct {
+	client   *Client
+	events   chan *Event
+	done     chan struct{}
+	overflow OverflowPolicy
+	lock     sync.RWMutex // held for reading while sending, and for writing while closing events
+	closed   bool
+}
+
+// Subscribe returns a channel through which events with the given names will be received, and a function that
+// unsubscribes and closes the channel. Names are given as for OnMany(). With no names, every event is received. See
+// SubscribeWith() for options.
+//
+// The subscription is made with the client's handlers, so it coexists with On(), survives reconnection, and receives
+// events in the order dictated by the client's Dispatch options.
+func (c *Client) Subscribe(eventNames ...string) (events <-chan *Event, cancel func()) {
+	return c.SubscribeWith(SubscribeOptions{}, eventNames...)
+}
+
+// SubscribeWith is the same as Subscribe(), but with the given buffer and overflow options.
+func (c *Client) SubscribeWith(options SubscribeOptions, eventNames ...string) (events <-chan *Event, cancel func()) {
+	size := options.Buffer
+	if size == 0 {
+		size = defaultDispatchQueueSize
+	} else if size < 0 {
+		size = 0
+	}
+	s := &subscription{
+		client:   c,
+		events:   make(chan *Event, size),
+		done:     make(chan struct{}),
+		overflow: options.Overflow,
+	}
+	if len(eventNames) == 0 {
+		eventNames = []string{"ALL"}
+	}
+	off := c.OnMany(s.deliver, eventNames...)
+	var once sync.Once
+	return s.events, func() {
+		once.Do(func() {
+			off()
+			close(s.done) // release blocked senders, so the lock can be taken
+			exclusive(&s.lock, func() {
+				s.closed = true
+				close(s.events)
+			})
+		})
+	}
+}
+
+func (s *subscription) deliver(e *Event) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if s.closed {
+		return
+	}
+	offer(s.client, s.events, e, s.overflow, s.done)
+}
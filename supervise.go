@@ -0,0 +1,110 @@
+package freeswitch
+
+import (
+	"context"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultBackoffInitial    = 500 * time.Millisecond
+	defaultBackoffMax        = 30 * time.Second
+	defaultBackoffMultiplier = 2
+	defaultBackoffJitter     = 0.2
+)
+
+// Backoff configures the delays between reconnection attempts made by Supervise(). Zero values are replaced with
+// defaults.
+type Backoff struct {
+	// The delay before the first reconnection attempt after a failure (default 500 milliseconds).
+	Initial time.Duration
+
+	// The longest delay between attempts (default 30 seconds).
+	Max time.Duration
+
+	// The factor by which the delay grows after each consecutive failure (default 2).
+	Multiplier float64
+
+	// The proportion by which each delay is randomly varied, e.g. 0.2 for ±20% (default 0.2). Use a negative value
+	// for no variation.
+	Jitter float64
+}
+
+// Supervise connects to FreeSWITCH, and reconnects whenever the connection fails or is lost, waiting between attempts
+// according to Backoff. The delay is reset each time a connection is fully established. Event subscriptions for
+// registered handlers are re-issued on every connection.
+//
+// Like Connect(), this method blocks, so call it in its own goroutine. It returns nil when Shutdown() is called, or
+// an error if the client's configuration prevents it from connecting at all. If Shutdown() has already been called,
+// it returns nil without connecting. Use OnStateChange to monitor progress.
+func (c *Client) Supervise() error {
+	ctx, halt := context.WithCancel(context.Background())
+	defer halt()
+
+	var supervising, shutdown bool
+	exclusive(&c.haltLock, func() {
+		shutdown = c.shutdown
+		if supervising = c.halt != nil; !supervising && !shutdown {
+			c.halt = halt
+		}
+	})
+	if shutdown {
+		return nil
+	}
+	if supervising {
+		return EAlreadyConnected
+	}
+	defer exclusive(&c.haltLock, func() { c.halt = nil })
+
+	for failures := 0; ; failures++ {
+		connected := atomic.LoadUint32(&c.connected)
+		err := c.ConnectContext(ctx)
+		if err == nil || ctx.Err() != nil {
+			return nil
+		}
+		if err == EAlreadyConnected || err == EBlankHostname {
+			return err
+		}
+		if atomic.LoadUint32(&c.connected) != connected {
+			failures = 0
+		}
+		select {
+		case <-time.After(c.Backoff.delay(failures)):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// The delay to wait after the given number of consecutive failures (beyond the first).
+func (b Backoff) delay(failures int) time.Duration {
+	var (
+		delay      = b.Initial
+		max        = b.Max
+		multiplier = b.Multiplier
+		jitter     = b.Jitter
+	)
+	if delay <= 0 {
+		delay = defaultBackoffInitial
+	}
+	if max <= 0 {
+		max = defaultBackoffMax
+	}
+	if multiplier <= 0 {
+		multiplier = defaultBackoffMultiplier
+	}
+	if jitter == 0 {
+		jitter = defaultBackoffJitter
+	}
+	for i := 0; i < failures && delay < max; i++ {
+		delay = time.Duration(float64(delay) * multiplier)
+	}
+	if delay > max {
+		delay = max
+	}
+	if jitter > 0 {
+		delay += time.Duration(float64(delay) * jitter * (rand.Float64()*2 - 1))
+	}
+	return delay
+}
@@ -1,7 +1,9 @@
 package freeswitch
 
 import (
+	"fmt"
 	"math/rand"
+	"strings"
 	"sync"
 )
 
@@ -24,26 +26,90 @@ func uniqueID() string {
 	return string(result)
 }
 
-func eventsSubscriptionCommand(eventNames ...EventName) (cmd []string) {
-	cmd = []string{"events", "plain"}
-	if len(eventNames) > 0 {
-		var (
-			normal []string
-			custom []string
-		)
-		for _, name := range eventNames {
-			if name.IsCustom() {
-				custom = append(custom, name.Subclass)
-			} else {
-				normal = append(normal, name.Name)
-			}
-		}
-		cmd = append(cmd, normal...)
-		if len(custom) > 0 {
-			cmd = append(cmd, append([]string{"CUSTOM"}, custom...)...)
+// Make a random (version 4) UUID, e.g. for use as a channel's Unique-ID.
+func newUUID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+func eventsSubscriptionCommand(format EventFormat, eventNames ...EventName) (cmd []string) {
+	if format == "" {
+		format = EventFormatPlain
+	}
+	cmd = []string{"events", string(format)}
+	for _, name := range eventNames {
+		if name.isWildcard() {
+			return append(cmd, "all")
 		}
+	}
+	if len(eventNames) > 0 {
+		cmd = append(cmd, eventNameArgs(eventNames)...)
 	} else {
 		cmd = append(cmd, "all")
 	}
 	return
 }
+
+// Format event names as arguments to the "events" and "nixevent" commands, with custom subclasses last, following
+// the word "CUSTOM".
+func eventNameArgs(eventNames []EventName) (args []string) {
+	var custom []string
+	for _, name := range eventNames {
+		if name.IsCustom() {
+			custom = append(custom, name.Subclass)
+		} else {
+			args = append(args, name.Name)
+		}
+	}
+	if len(custom) > 0 {
+		args = append(args, append([]string{"CUSTOM"}, custom...)...)
+	}
+	return
+}
+
+// Returns a "nixevent" command for every event not among the given names, to end a subscription to all events without
+// forgetting the others. Custom events are then only sent for subclasses given to "events", so aren't nixed.
+func nixUnneededEventsCommand(needed []EventName) []string {
+	keep := map[string]bool{}
+	for _, name := range needed {
+		keep[name.Name] = true
+	}
+	cmd := []string{"nixevent"}
+	for _, name := range coreEventNames {
+		if !keep[name] {
+			cmd = append(cmd, name)
+		}
+	}
+	return cmd
+}
+
+// The names of FreeSWITCH's events, other than CUSTOM and ALL, from switch_event.c. Any missing from this list are
+// still sent after a subscription to all events ends, but they have no handlers, so are discarded.
+var coreEventNames = []string{
+	"CLONE", "CHANNEL_CREATE", "CHANNEL_DESTROY", "CHANNEL_STATE", "CHANNEL_CALLSTATE", "CHANNEL_ANSWER",
+	"CHANNEL_HANGUP", "CHANNEL_HANGUP_COMPLETE", "CHANNEL_EXECUTE", "CHANNEL_EXECUTE_COMPLETE", "CHANNEL_HOLD",
+	"CHANNEL_UNHOLD", "CHANNEL_BRIDGE", "CHANNEL_UNBRIDGE", "CHANNEL_PROGRESS", "CHANNEL_PROGRESS_MEDIA",
+	"CHANNEL_OUTGOING", "CHANNEL_PARK", "CHANNEL_UNPARK", "CHANNEL_APPLICATION", "CHANNEL_ORIGINATE", "CHANNEL_UUID",
+	"API", "LOG", "INBOUND_CHAN", "OUTBOUND_CHAN", "STARTUP", "SHUTDOWN", "PUBLISH", "UNPUBLISH", "TALK", "NOTALK",
+	"SESSION_CRASH", "MODULE_LOAD", "MODULE_UNLOAD", "DTMF", "MESSAGE", "PRESENCE_IN", "NOTIFY_IN", "PRESENCE_OUT",
+	"PRESENCE_PROBE", "MESSAGE_WAITING", "MESSAGE_QUERY", "ROSTER", "CODEC", "BACKGROUND_JOB", "DETECTED_SPEECH",
+	"DETECTED_TONE", "PRIVATE_COMMAND", "HEARTBEAT", "TRAP", "ADD_SCHEDULE", "DEL_SCHEDULE", "EXE_SCHEDULE",
+	"RE_SCHEDULE", "RELOADXML", "NOTIFY", "PHONE_FEATURE", "PHONE_FEATURE_SUBSCRIBE", "SEND_MESSAGE", "RECV_MESSAGE",
+	"REQUEST_PARAMS", "CHANNEL_DATA", "GENERAL", "COMMAND", "SESSION_HEARTBEAT", "CLIENT_DISCONNECTED",
+	"SERVER_DISCONNECTED", "SEND_INFO", "RECV_INFO", "RECV_RTCP_MESSAGE", "SEND_RTCP_MESSAGE", "CALL_SECURE", "NAT",
+	"RECORD_START", "RECORD_STOP", "PLAYBACK_START", "PLAYBACK_STOP", "CALL_UPDATE", "FAILURE", "SOCKET_DATA",
+	"MEDIA_BUG_START", "MEDIA_BUG_STOP", "CONFERENCE_DATA_QUERY", "CONFERENCE_DATA", "CALL_SETUP_REQ",
+	"CALL_SETUP_RESULT", "CALL_DETAIL", "DEVICE_STATE", "TEXT", "SHUTDOWN_REQUESTED",
+}
+
+// Parse an event name in the same format as eventsSubscriptionCommand(), e.g. "CHANNEL_ANSWER" or
+// "CUSTOM sofia::register".
+func parseEventName(name string) EventName {
+	if strings.HasPrefix(name, "CUSTOM ") {
+		return EventName{"CUSTOM", strings.TrimSpace(strings.TrimPrefix(name, "CUSTOM "))}
+	}
+	return EventName{name, ""}
+}
@@ -0,0 +1,127 @@
+package freeswitch
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// The prefix of headers that carry channel variables.
+const variablePrefix = "variable_"
+
+// The prefix and separator of FreeSWITCH array values, e.g. "ARRAY::a|:b".
+const (
+	arrayPrefix    = "ARRAY::"
+	arraySeparator = "|:"
+)
+
+// Variables are channel variables, e.g. origination_caller_id_number, keyed by name.
+type Variables map[string]string
+
+// Array returns the elements of an array variable. See ParseArray().
+func (v Variables) Array(name string) []string {
+	return ParseArray(v[name])
+}
+
+// SetArray sets a variable to an array of values. See FormatArray().
+func (v Variables) SetArray(name string, values ...string) {
+	v[name] = FormatArray(values...)
+}
+
+// ParseArray splits a FreeSWITCH array value, e.g. "ARRAY::a|:b", into its elements. A value that isn't an array is
+// returned as a single element, unless it's empty.
+func ParseArray(value string) []string {
+	if !strings.HasPrefix(value, arrayPrefix) {
+		if value == "" {
+			return nil
+		}
+		return []string{value}
+	}
+	return strings.Split(value[len(arrayPrefix):], arraySeparator)
+}
+
+// FormatArray joins values into a FreeSWITCH array value, e.g. "ARRAY::a|:b".
+func FormatArray(values ...string) string {
+	return arrayPrefix + strings.Join(values, arraySeparator)
+}
+
+// Variables returns the event's channel variables, from its "variable_" headers.
+func (e *Event) Variables() Variables {
+	e.read()
+	variables := Variables{}
+	for _, h := range e.headers.list {
+		if name, ok := trimPrefixFold(h.name, variablePrefix); ok {
+			variables[name] = h.value
+		}
+	}
+	return variables
+}
+
+// Variable returns the value of the given channel variable, or an empty string if the event doesn't have it. Use
+// ParseArray() for array values.
+func (e *Event) Variable(name string) string {
+	return e.Get(variablePrefix + name)
+}
+
+// SetVariable sets a channel variable on the event, e.g. before sending it with Send(). Use FormatArray() for array
+// values.
+func (e *Event) SetVariable(name, value string) *Event {
+	return e.Set(variablePrefix+name, value)
+}
+
+// Returns str without the given prefix, and true, if str has the prefix, ignoring case, and is longer.
+func trimPrefixFold(str, prefix string) (string, bool) {
+	if len(str) > len(prefix) && strings.EqualFold(str[:len(prefix)], prefix) {
+		return str[len(prefix):], true
+	}
+	return "", false
+}
+
+// Encode variables for a dial string, between the given delimiters, e.g. {a=1,b=2}. Returns an empty string if
+// there are no variables. Names are sorted, so that the result is predictable.
+func (v Variables) encode(open, close byte) string {
+	if len(v) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(v))
+	for name := range v {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + "=" + escapeVariable(v[name])
+	}
+	return string(open) + strings.Join(pairs, ",") + string(close)
+}
+
+// Returns an error if a variable's value would end the delimiters given to encode() early, or not at all. FreeSWITCH
+// finds the end of the variables by counting delimiters, ignoring quotes and escapes, so values such as "${a}" are
+// allowed between {}, but "a}" is not.
+func (v Variables) check(open, close byte) error {
+	for name, value := range v {
+		depth := 0
+		for i := 0; i < len(value) && depth >= 0; i++ {
+			switch value[i] {
+			case open:
+				depth++
+			case close:
+				depth--
+			}
+		}
+		if depth != 0 {
+			return fmt.Errorf("freeswitch: variable %s can't contain unbalanced %c%c", name, open, close)
+		}
+	}
+	return nil
+}
+
+// Escape a variable value for use in a dial string. Commas would otherwise separate variables, and spaces would
+// otherwise separate arguments.
+func escapeVariable(value string) string {
+	value = strings.Replace(value, ",", `\,`, -1)
+	if strings.ContainsAny(value, " '") {
+		value = "'" + strings.Replace(value, "'", `\'`, -1) + "'"
+	}
+	return value
+}